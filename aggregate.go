@@ -0,0 +1,171 @@
+package statsd
+
+import "sync"
+
+// An aggregator aggregates metrics between two flushes.
+type aggregator struct {
+	// basic is true if counters, gauges and sets are aggregated.
+	basic bool
+	// extended is true if timings, histograms and distributions are buffered
+	// to be sent as multi-value lines.
+	extended bool
+
+	mu      sync.Mutex
+	index   map[aggKey]int
+	metrics []aggMetric
+	// spare holds the metrics of the previous flush so that they can be
+	// reused. It is guarded by the mutex of the first conn shard.
+	spare []aggMetric
+}
+
+type aggKey struct {
+	prefix, bucket, tags, typ string
+	rate                      float32
+}
+
+type aggMetric struct {
+	aggKey
+	count number
+	gauge number
+	// set and values hold the distinct values of a set, values keeps them in
+	// the order they were received.
+	set    map[string]struct{}
+	values []string
+	// samples holds the values of timings, histograms and distributions.
+	samples []float64
+}
+
+func newAggregator(basic, extended bool) *aggregator {
+	return &aggregator{
+		basic:    basic,
+		extended: extended,
+		index:    make(map[aggKey]int),
+	}
+}
+
+// get returns the metric associated with k. a.mu must be held.
+func (a *aggregator) get(k aggKey) *aggMetric {
+	if i, ok := a.index[k]; ok {
+		return &a.metrics[i]
+	}
+	a.index[k] = len(a.metrics)
+	a.metrics = append(a.metrics, aggMetric{aggKey: k})
+	return &a.metrics[len(a.metrics)-1]
+}
+
+// metric aggregates a counter or buffers a sample. It returns false if the
+// metric is not aggregated and must be sent as is.
+func (a *aggregator) metric(prefix, bucket string, n number, typ string, rate float32, tags string) bool {
+	switch typ {
+	case "c":
+		if !a.basic {
+			return false
+		}
+	case "ms", "h", "d":
+		if !a.extended {
+			return false
+		}
+	default:
+		return false
+	}
+	a.mu.Lock()
+	m := a.get(aggKey{prefix: prefix, bucket: bucket, tags: tags, typ: typ, rate: rate})
+	if typ == "c" {
+		m.count = m.count.add(n)
+	} else {
+		m.samples = append(m.samples, n.float64())
+	}
+	a.mu.Unlock()
+	return true
+}
+
+func (a *aggregator) gauge(prefix, bucket string, value number, tags string) bool {
+	if !a.basic {
+		return false
+	}
+	a.mu.Lock()
+	a.get(aggKey{prefix: prefix, bucket: bucket, tags: tags, typ: "g"}).gauge = value
+	a.mu.Unlock()
+	return true
+}
+
+func (a *aggregator) unique(prefix, bucket string, value string, tags string) bool {
+	if !a.basic {
+		return false
+	}
+	a.mu.Lock()
+	m := a.get(aggKey{prefix: prefix, bucket: bucket, tags: tags, typ: "s"})
+	if _, ok := m.set[value]; !ok {
+		if m.set == nil {
+			m.set = make(map[string]struct{})
+		}
+		m.set[value] = struct{}{}
+		m.values = append(m.values, value)
+	}
+	a.mu.Unlock()
+	return true
+}
+
+// appendTo appends the aggregated metrics to the buffer of c and resets the
+// aggregator. c.mu must be held.
+func (a *aggregator) appendTo(c *conn) {
+	a.mu.Lock()
+	metrics := a.metrics
+	a.metrics = a.spare[:0]
+	for k := range a.index {
+		delete(a.index, k)
+	}
+	a.mu.Unlock()
+
+	for i := range metrics {
+		m := &metrics[i]
+		switch m.typ {
+		case "c":
+			c.appendMetric(m.prefix, m.bucket, m.count, "c", m.rate, m.tags)
+		case "g":
+			c.appendGaugeMetric(m.prefix, m.bucket, m.gauge, m.tags)
+		case "s":
+			for _, v := range m.values {
+				c.appendUniqueMetric(m.prefix, m.bucket, v, m.tags)
+			}
+		default:
+			c.appendSamples(m)
+		}
+		metrics[i] = aggMetric{}
+	}
+	a.spare = metrics[:0]
+}
+
+// appendSamples appends the samples of m packed in as few lines as possible:
+// bucket:v1:v2:v3|ms. A line is split when it would exceed the maximum packet
+// size.
+func (c *conn) appendSamples(m *aggMetric) {
+	values := m.samples
+	for len(values) > 0 {
+		l := len(c.buf)
+		c.appendBucket(m.prefix, m.bucket, m.tags)
+		c.appendNumber(float64Value(values[0]))
+
+		// Render the end of the line once to know its length.
+		end := len(c.buf)
+		c.appendType(m.typ)
+		c.appendRate(m.rate)
+		c.closeMetric(m.tags)
+		c.suffix = append(c.suffix[:0], c.buf[end:]...)
+		c.buf = c.buf[:end]
+
+		i := 1
+		for ; i < len(values); i++ {
+			n := len(c.buf)
+			c.appendByte(':')
+			c.appendNumber(float64Value(values[i]))
+			if len(c.buf)-l+len(c.suffix) > c.maxPacketSize {
+				c.buf = c.buf[:n]
+				break
+			}
+		}
+		c.buf = append(c.buf, c.suffix...)
+		values = values[i:]
+		c.flushIfBufferFull(l)
+	}
+}
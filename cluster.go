@@ -0,0 +1,220 @@
+package statsd
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A ClusterClient spreads the metrics across several StatsD daemons. Each
+// metric is routed to a node chosen by a consistent hash of its bucket so that
+// a bucket is always aggregated by the same node, even when nodes are added or
+// removed from the list.
+//
+// Each node is a Client with its own connection and buffer, so MaxPacketSize
+// applies to each node.
+type ClusterClient struct {
+	ring     *ring
+	clients  []*Client
+	hashTags bool
+}
+
+// NewClusterClient returns a ClusterClient sending metrics to the given
+// addresses. The options are used to create the Client of each node and must
+// not include the Address option.
+//
+// If some nodes cannot be reached, their errors are returned as
+// DestinationErrors but the ClusterClient is still usable: the Clients of
+// these nodes keep their packets and dial them in the background as with the
+// BackgroundDial option. If addrs is empty, NewClusterClient panics.
+func NewClusterClient(addrs []string, opts ...Option) (*ClusterClient, error) {
+	if len(addrs) == 0 {
+		panic("statsd: NewClusterClient needs at least one address")
+	}
+	conf := &config{}
+	for _, o := range opts {
+		o(conf)
+	}
+	c := &ClusterClient{
+		ring:     newRing(addrs),
+		clients:  make([]*Client, len(addrs)),
+		hashTags: conf.Client.HashTags,
+	}
+
+	var errs writeErrors
+	for i, addr := range addrs {
+		nodeOpts := append(opts[:len(opts):len(opts)], Address(addr))
+		client, err := New(nodeOpts...)
+		if err != nil {
+			errs = append(errs, &DestinationError{Addr: addr, Err: err})
+			// Do not lose the buckets of the node until it is back.
+			client, _ = New(append(nodeOpts, BackgroundDial(true))...)
+		}
+		c.clients[i] = client
+	}
+	return c, errs.err()
+}
+
+// Clients returns the Clients of c, one per node in the order of the
+// addresses.
+func (c *ClusterClient) Clients() []*Client {
+	return c.clients
+}
+
+// Clone returns a ClusterClient whose Clients are clones of the Clients of c
+// created with the given options.
+func (c *ClusterClient) Clone(opts ...Option) *ClusterClient {
+	clients := make([]*Client, len(c.clients))
+	for i, client := range c.clients {
+		clients[i] = client.Clone(opts...)
+	}
+	return &ClusterClient{ring: c.ring, clients: clients, hashTags: c.hashTags}
+}
+
+// node returns the Client of the node responsible for bucket.
+func (c *ClusterClient) node(bucket string, tags []string) *Client {
+	if len(c.clients) == 1 {
+		return c.clients[0]
+	}
+	first := c.clients[0]
+	h := hashString(fnvOffset, first.prefix)
+	h = hashString(h, bucket)
+	if c.hashTags {
+		h = hashString(h, first.withTags(tags))
+	}
+	return c.clients[c.ring.get(h)]
+}
+
+// Count adds n to bucket.
+func (c *ClusterClient) Count(bucket string, n interface{}, tags ...string) {
+	c.node(bucket, tags).Count(bucket, n, tags...)
+}
+
+// Increment increment the given bucket.
+func (c *ClusterClient) Increment(bucket string, tags ...string) {
+	c.node(bucket, tags).Increment(bucket, tags...)
+}
+
+// Gauge records an absolute value for the given bucket.
+func (c *ClusterClient) Gauge(bucket string, value interface{}, tags ...string) {
+	c.node(bucket, tags).Gauge(bucket, value, tags...)
+}
+
+// Timing sends a timing value to a bucket.
+func (c *ClusterClient) Timing(bucket string, value interface{}, tags ...string) {
+	c.node(bucket, tags).Timing(bucket, value, tags...)
+}
+
+// TimingDuration sends d to a bucket as a timing value in milliseconds.
+func (c *ClusterClient) TimingDuration(bucket string, d time.Duration, tags ...string) {
+	c.node(bucket, tags).TimingDuration(bucket, d, tags...)
+}
+
+// Histogram sends an histogram value to a bucket.
+func (c *ClusterClient) Histogram(bucket string, value interface{}, tags ...string) {
+	c.node(bucket, tags).Histogram(bucket, value, tags...)
+}
+
+// Distribution sends a distribution value to a bucket.
+func (c *ClusterClient) Distribution(bucket string, value interface{}, tags ...string) {
+	c.node(bucket, tags).Distribution(bucket, value, tags...)
+}
+
+// Unique sends the given value to a set bucket.
+func (c *ClusterClient) Unique(bucket string, value string, tags ...string) {
+	c.node(bucket, tags).Unique(bucket, value, tags...)
+}
+
+// Flush flushes the buffers of all the nodes.
+func (c *ClusterClient) Flush() {
+	for _, client := range c.clients {
+		client.Flush()
+	}
+}
+
+// FlushContext is like Client.FlushContext. The errors of each node are
+// returned as DestinationErrors.
+func (c *ClusterClient) FlushContext(ctx context.Context) error {
+	return NewMultiClient(c.clients...).FlushContext(ctx)
+}
+
+// Close closes all the nodes.
+func (c *ClusterClient) Close() {
+	for _, client := range c.clients {
+		client.Close()
+	}
+}
+
+// CloseContext is like Client.CloseContext. The errors of each node are
+// returned as DestinationErrors.
+func (c *ClusterClient) CloseContext(ctx context.Context) error {
+	return NewMultiClient(c.clients...).CloseContext(ctx)
+}
+
+// ringReplicas is the number of points of each node on the ring.
+const ringReplicas = 160
+
+// A ring is a consistent hash ring. Each node is placed at several points of
+// the ring so that the keys are evenly spread.
+type ring struct {
+	points []ringPoint
+}
+
+type ringPoint struct {
+	hash uint32
+	node int
+}
+
+func newRing(addrs []string) *ring {
+	r := &ring{points: make([]ringPoint, 0, len(addrs)*ringReplicas)}
+	for i, addr := range addrs {
+		for j := 0; j < ringReplicas; j++ {
+			h := hashString(fnvOffset, addr)
+			h = hashString(h, "-"+strconv.Itoa(j))
+			r.points = append(r.points, ringPoint{hash: mix(h), node: i})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r
+}
+
+// get returns the node owning the hash h: the node of the first point whose
+// hash is greater or equal to h.
+func (r *ring) get(h uint32) int {
+	h = mix(h)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+const (
+	fnvOffset = 2166136261
+	fnvPrime  = 16777619
+)
+
+// hashString adds s to the 32-bit FNV-1a hash h.
+func hashString(h uint32, s string) uint32 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= fnvPrime
+	}
+	return h
+}
+
+// mix spreads the bits of h over the whole 32 bits since FNV hashes of similar
+// strings are close to each other. It is the finalizer of MurmurHash3.
+func mix(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -1,88 +1,255 @@
 package statsd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"math/rand"
 	"net"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 type conn struct {
+	// stats is accessed atomically so it must stay the first field to be
+	// 64-bit aligned on 32-bit platforms.
+	stats connStats
+
 	// Fields settable with options at Client's creation.
 	addr          string
 	errorHandler  func(error)
 	flushPeriod   time.Duration
 	maxPacketSize int
 	network       string
-	tagFormat     TagFormat
+	tagFormat     TagFormatter
+	tagPlacement  TagPlacement
+	sanitizeMode  SanitizeMode
+	// bucketReserved, tagKeyReserved and tagValueReserved are the characters
+	// handled by sanitize.
+	bucketReserved   string
+	tagKeyReserved   string
+	tagValueReserved string
+	dialTimeout      time.Duration
+	reconnectMin     time.Duration
+	reconnectMax     time.Duration
+	maxPending       int
+	writeTimeout     time.Duration
+	telemetry        time.Duration
+	resolvePeriod    time.Duration
+	lookupHost       func(string) ([]string, error)
+	queuePolicy      QueuePolicy
+	// agg is nil if client-side aggregation is disabled. It is shared by the
+	// shards.
+	agg *aggregator
+	// shards holds the buffers metrics are spread across, starting with the
+	// conn itself. owner is the conn holding the connection, the sender
+	// goroutine and the ErrorHandler; it is the conn itself for the first
+	// shard.
+	shards []*conn
+	owner  *conn
+	// queue is nil if packets are sent synchronously. Otherwise, the packets
+	// are sent by the sender goroutine until stop is closed. The queue itself
+	// is never closed, the packets sent after stop are dropped.
+	queue chan packet
+	free  chan []byte
+	stop  chan struct{}
 
 	mu sync.Mutex
 	// Fields guarded by the mutex.
 	closed    bool
-	w         io.WriteCloser
 	buf       []byte
 	rateCache map[float32]string
+	// suffix is a scratch buffer used when packing multi-value lines.
+	suffix []byte
+
+	// wmu guards the connection to the StatsD daemon. When both mutexes are
+	// needed, mu must be locked first.
+	wmu     sync.Mutex
+	wClosed bool
+	w       io.WriteCloser
+	// pending holds the packets that could not be sent while the connection
+	// was down.
+	pending [][]byte
+	// collecting is the number of running FlushContext and CloseContext calls.
+	// While it is positive, the write errors are kept in errs and the writes
+	// use deadline if it is not zero.
+	collecting int
+	errs       []error
+	deadline   time.Time
+
+	// closing is set atomically when the conn starts closing.
+	closing uint32
+
+	// errMu serializes the calls to the ErrorHandler of all the shards.
+	errMu sync.Mutex
 }
 
 func newConn(conf connConfig, muted bool) (*conn, error) {
-	c := &conn{
-		addr:          conf.Addr,
-		errorHandler:  conf.ErrorHandler,
-		flushPeriod:   conf.FlushPeriod,
-		maxPacketSize: conf.MaxPacketSize,
-		network:       conf.Network,
-		tagFormat:     conf.TagFormat,
+	c := newShard(conf)
+	c.owner = c
+	if conf.Aggregate || conf.ExtendedAggregate {
+		c.agg = newAggregator(conf.Aggregate, conf.ExtendedAggregate)
+	}
+	c.shards = []*conn{c}
+	for i := 1; i < conf.Shards; i++ {
+		s := newShard(conf)
+		s.owner = c
+		s.agg = c.agg
+		c.shards = append(c.shards, s)
 	}
 
 	if muted {
 		return c, nil
 	}
 
-	var err error
-	c.w, err = dialTimeout(c.network, c.addr, 5*time.Second)
-	if err != nil {
+	if conf.BackgroundDial {
+		// The packets are kept as pending until the connection is established.
+		go c.reconnect()
+	} else if err := c.connect(); err != nil {
 		return c, err
 	}
-	// When using UDP do a quick check to see if something is listening on the
-	// given port to return an error as soon as possible.
-	if c.network[:3] == "udp" {
-		for i := 0; i < 2; i++ {
-			_, err = c.w.Write(nil)
-			if err != nil {
-				_ = c.w.Close()
-				c.w = nil
-				return c, err
-			}
-		}
-	}
 
 	// To prevent a buffer overflow add some capacity to the buffer to allow for
 	// an additional metric.
-	c.buf = make([]byte, 0, c.maxPacketSize+200)
+	for _, s := range c.shards {
+		s.buf = make([]byte, 0, c.maxPacketSize+200)
+	}
 
 	if c.flushPeriod > 0 {
 		go func() {
 			ticker := time.NewTicker(c.flushPeriod)
 			for _ = range ticker.C {
 				c.mu.Lock()
-				if c.closed {
+				closed := c.closed
+				c.mu.Unlock()
+				if closed {
 					ticker.Stop()
-					c.mu.Unlock()
 					return
 				}
-				c.flush(0)
-				c.mu.Unlock()
+				if c.flushShards() {
+					atomic.AddUint64(&c.stats.periodicFlushes, 1)
+				}
 			}
 		}()
 	}
+	if conf.QueueSize > 0 {
+		c.queue = make(chan packet, conf.QueueSize)
+		c.free = make(chan []byte, conf.QueueSize+1)
+		c.stop = make(chan struct{})
+		go c.sender()
+	}
+	if c.telemetry > 0 {
+		go c.sendTelemetry()
+	}
+	if c.resolvePeriod > 0 && !strings.HasPrefix(c.network, "unix") {
+		host, _, err := net.SplitHostPort(c.addr)
+		if err == nil && host != "" && net.ParseIP(host) == nil {
+			c.lookupHost = lookupHost
+			addrs, _ := c.lookup(host)
+			go c.resolve(host, addrs)
+		}
+	}
 
 	return c, nil
 }
 
-func (c *conn) metric(prefix, bucket string, n interface{}, typ string, rate float32, tags string) {
-	c.mu.Lock()
+// connect dials the StatsD daemon.
+func (c *conn) connect() error {
+	var err error
+	c.w, err = c.dial()
+	if err != nil {
+		return err
+	}
+	// When using UDP do a quick check to see if something is listening on the
+	// given port to return an error as soon as possible. Unix sockets already
+	// fail when dialing if nothing is listening.
+	if strings.HasPrefix(c.network, "udp") {
+		for i := 0; i < 2; i++ {
+			_, err = c.w.Write(nil)
+			if err != nil {
+				_ = c.w.Close()
+				c.w = nil
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newShard returns a conn configured with conf but without a connection.
+func newShard(conf connConfig) *conn {
+	c := &conn{
+		addr:          conf.Addr,
+		errorHandler:  conf.ErrorHandler,
+		flushPeriod:   conf.FlushPeriod,
+		maxPacketSize: conf.MaxPacketSize,
+		network:       conf.Network,
+		tagFormat:     conf.TagFormat,
+		tagPlacement:  TagsAfterBucket,
+		sanitizeMode:  conf.SanitizeMode,
+		dialTimeout:   conf.DialTimeout,
+		reconnectMin:  conf.ReconnectMin,
+		reconnectMax:  conf.ReconnectMax,
+		maxPending:    conf.MaxPending,
+		writeTimeout:  conf.WriteTimeout,
+		telemetry:     conf.Telemetry,
+		resolvePeriod: conf.ResolvePeriod,
+		queuePolicy:   conf.QueuePolicy,
+	}
+	if c.tagFormat != nil {
+		c.tagPlacement = c.tagFormat.Placement()
+	}
+	c.bucketReserved, c.tagKeyReserved, c.tagValueReserved = reservedChars(c.tagFormat)
+	return c
+}
+
+// shard returns the conn whose buffer receives the metric identified by
+// prefix, bucket and tags. A metric always goes to the same shard so that its
+// values are sent in order.
+func (c *conn) shard(prefix, bucket, tags string) *conn {
+	if len(c.shards) == 1 {
+		return c
+	}
+	h := hashString(fnvOffset, prefix)
+	h = hashString(h, bucket)
+	h = hashString(h, tags)
+	return c.shards[mix(h)%uint32(len(c.shards))]
+}
+
+// flushShards flushes the buffers of all the shards. It returns false if there
+// was nothing to flush.
+func (c *conn) flushShards() bool {
+	flushed := false
+	for _, s := range c.shards {
+		s.mu.Lock()
+		if s.flushAll() {
+			flushed = true
+		}
+		s.mu.Unlock()
+	}
+	return flushed
+}
+
+func (c *conn) metric(prefix, bucket string, n number, typ string, rate float32, tags string) {
+	bucket = c.sanitize(bucket, c.bucketReserved)
+	s := c.shard(prefix, bucket, tags)
+	atomic.AddUint64(&s.stats.metricsQueued, 1)
+	if c.agg != nil && c.agg.metric(prefix, bucket, n, typ, rate, tags) {
+		return
+	}
+	s.mu.Lock()
+	s.appendMetric(prefix, bucket, n, typ, rate, tags)
+	s.mu.Unlock()
+}
+
+func (c *conn) appendMetric(prefix, bucket string, n number, typ string, rate float32, tags string) {
 	l := len(c.buf)
 	c.appendBucket(prefix, bucket, tags)
 	c.appendNumber(n)
@@ -90,39 +257,59 @@ func (c *conn) metric(prefix, bucket string, n interface{}, typ string, rate flo
 	c.appendRate(rate)
 	c.closeMetric(tags)
 	c.flushIfBufferFull(l)
-	c.mu.Unlock()
 }
 
-func (c *conn) gauge(prefix, bucket string, value interface{}, tags string) {
-	c.mu.Lock()
+func (c *conn) gauge(prefix, bucket string, value number, tags string) {
+	bucket = c.sanitize(bucket, c.bucketReserved)
+	s := c.shard(prefix, bucket, tags)
+	atomic.AddUint64(&s.stats.metricsQueued, 1)
+	if c.agg != nil && c.agg.gauge(prefix, bucket, value, tags) {
+		return
+	}
+	s.mu.Lock()
+	s.appendGaugeMetric(prefix, bucket, value, tags)
+	s.mu.Unlock()
+}
+
+func (c *conn) appendGaugeMetric(prefix, bucket string, value number, tags string) {
 	l := len(c.buf)
 	// To set a gauge to a negative value we must first set it to 0.
 	// https://github.com/etsy/statsd/blob/master/docs/metric_types.md#gauges
-	if isNegative(value) {
+	if value.isNegative() {
 		c.appendBucket(prefix, bucket, tags)
-		c.appendGauge(0, tags)
+		c.appendGauge(intValue(0), tags)
 	}
 	c.appendBucket(prefix, bucket, tags)
 	c.appendGauge(value, tags)
 	c.flushIfBufferFull(l)
-	c.mu.Unlock()
 }
 
-func (c *conn) appendGauge(value interface{}, tags string) {
+func (c *conn) appendGauge(value number, tags string) {
 	c.appendNumber(value)
 	c.appendType("g")
 	c.closeMetric(tags)
 }
 
 func (c *conn) unique(prefix, bucket string, value string, tags string) {
-	c.mu.Lock()
+	bucket = c.sanitize(bucket, c.bucketReserved)
+	s := c.shard(prefix, bucket, tags)
+	atomic.AddUint64(&s.stats.metricsQueued, 1)
+	value = c.sanitize(value, valueReserved)
+	if c.agg != nil && c.agg.unique(prefix, bucket, value, tags) {
+		return
+	}
+	s.mu.Lock()
+	s.appendUniqueMetric(prefix, bucket, value, tags)
+	s.mu.Unlock()
+}
+
+func (c *conn) appendUniqueMetric(prefix, bucket string, value string, tags string) {
 	l := len(c.buf)
 	c.appendBucket(prefix, bucket, tags)
 	c.appendString(value)
 	c.appendType("s")
 	c.closeMetric(tags)
 	c.flushIfBufferFull(l)
-	c.mu.Unlock()
 }
 
 func (c *conn) appendByte(b byte) {
@@ -133,69 +320,26 @@ func (c *conn) appendString(s string) {
 	c.buf = append(c.buf, s...)
 }
 
-func (c *conn) appendNumber(v interface{}) {
-	switch n := v.(type) {
-	case int:
-		c.buf = strconv.AppendInt(c.buf, int64(n), 10)
-	case uint:
-		c.buf = strconv.AppendUint(c.buf, uint64(n), 10)
-	case int64:
-		c.buf = strconv.AppendInt(c.buf, n, 10)
-	case uint64:
-		c.buf = strconv.AppendUint(c.buf, n, 10)
-	case int32:
-		c.buf = strconv.AppendInt(c.buf, int64(n), 10)
-	case uint32:
-		c.buf = strconv.AppendUint(c.buf, uint64(n), 10)
-	case int16:
-		c.buf = strconv.AppendInt(c.buf, int64(n), 10)
-	case uint16:
-		c.buf = strconv.AppendUint(c.buf, uint64(n), 10)
-	case int8:
-		c.buf = strconv.AppendInt(c.buf, int64(n), 10)
-	case uint8:
-		c.buf = strconv.AppendUint(c.buf, uint64(n), 10)
-	case float64:
-		c.buf = strconv.AppendFloat(c.buf, n, 'f', -1, 64)
-	case float32:
-		c.buf = strconv.AppendFloat(c.buf, float64(n), 'f', -1, 32)
-	}
-}
-
-func isNegative(v interface{}) bool {
-	switch n := v.(type) {
-	case int:
-		return n < 0
-	case uint:
-		return n < 0
-	case int64:
-		return n < 0
-	case uint64:
-		return n < 0
-	case int32:
-		return n < 0
-	case uint32:
-		return n < 0
-	case int16:
-		return n < 0
-	case uint16:
-		return n < 0
-	case int8:
-		return n < 0
-	case uint8:
-		return n < 0
-	case float64:
-		return n < 0
-	case float32:
-		return n < 0
+func (c *conn) appendNumber(n number) {
+	switch n.kind {
+	case kindInt:
+		c.buf = strconv.AppendInt(c.buf, n.i, 10)
+	case kindUint:
+		c.buf = strconv.AppendUint(c.buf, n.u, 10)
+	case kindFloat64:
+		c.buf = strconv.AppendFloat(c.buf, n.f, 'f', -1, 64)
+	case kindFloat32:
+		c.buf = strconv.AppendFloat(c.buf, n.f, 'f', -1, 32)
 	}
-	return false
 }
 
 func (c *conn) appendBucket(prefix, bucket string, tags string) {
+	if c.tagPlacement == TagsBeforeBucket {
+		c.appendString(tags)
+	}
 	c.appendString(prefix)
 	c.appendString(bucket)
-	if c.tagFormat == InfluxDB {
+	if c.tagPlacement == TagsAfterBucket {
 		c.appendString(tags)
 	}
 	c.appendByte(':')
@@ -225,46 +369,481 @@ func (c *conn) appendRate(rate float32) {
 }
 
 func (c *conn) closeMetric(tags string) {
-	if c.tagFormat == Datadog {
+	if c.tagPlacement == TagsAfterType {
 		c.appendString(tags)
 	}
 	c.appendByte('\n')
 }
 
 func (c *conn) flushIfBufferFull(lastSafeLen int) {
-	if len(c.buf) > c.maxPacketSize {
-		c.flush(lastSafeLen)
+	if len(c.buf) > c.maxPacketSize && c.flush(lastSafeLen) {
+		atomic.AddUint64(&c.stats.sizeFlushes, 1)
+	}
+}
+
+// flushAll appends the aggregated metrics to the buffer and flushes the whole
+// buffer. It returns false if there was nothing to flush. The aggregated
+// metrics are only appended to the buffer of the first shard.
+func (c *conn) flushAll() bool {
+	if c.agg != nil && c.owner == c {
+		c.agg.appendTo(c)
 	}
+	return c.flush(0)
 }
 
 // flush flushes the first n bytes of the buffer.
-// If n is 0, the whole buffer is flushed.
-func (c *conn) flush(n int) {
+// If n is 0, the whole buffer is flushed. It returns false if there was
+// nothing to flush.
+func (c *conn) flush(n int) bool {
 	if len(c.buf) == 0 {
-		return
+		return false
 	}
 	if n == 0 {
 		n = len(c.buf)
 	}
 
-	// Trim the last \n, StatsD does not like it.
-	_, err := c.w.Write(c.buf[:n-1])
-	c.handleError(err)
+	if isStream(c.network) {
+		// Lines must always be terminated on stream connections, otherwise
+		// the last line would be merged with the first line of the next
+		// flush.
+		c.send(c.buf[:n])
+	} else {
+		// Trim the last \n, StatsD does not like it.
+		c.send(c.buf[:n-1])
+	}
 	if n < len(c.buf) {
 		copy(c.buf, c.buf[n:])
 	}
 	c.buf = c.buf[:len(c.buf)-n]
+	return true
+}
+
+// send writes p to the connection or hands it to the sender goroutine.
+// c.mu must be held.
+func (c *conn) send(p []byte) {
+	o := c.owner
+	if o.queue == nil {
+		o.wmu.Lock()
+		o.write(p)
+		o.wmu.Unlock()
+		return
+	}
+	if c.closed {
+		atomic.AddUint64(&c.stats.packetsDropped, 1)
+		return
+	}
+
+	var b []byte
+	select {
+	case b = <-o.free:
+	default:
+		b = make([]byte, 0, c.maxPacketSize)
+	}
+	b = append(b[:0], p...)
+	if c.queuePolicy == BlockWhenFull {
+		o.queue <- packet{p: b}
+		return
+	}
+	select {
+	case o.queue <- packet{p: b}:
+	default:
+		atomic.AddUint64(&c.stats.packetsDropped, 1)
+		o.release(b)
+	}
+}
+
+// A packet is an item of the send queue. If done is not nil, p is empty and
+// done is closed once the previous packets have been written.
+type packet struct {
+	p    []byte
+	done chan struct{}
+}
+
+// sender writes the queued packets until stop is closed.
+func (c *conn) sender() {
+	for {
+		select {
+		case pk := <-c.queue:
+			if pk.done != nil {
+				close(pk.done)
+				continue
+			}
+			c.wmu.Lock()
+			c.write(pk.p)
+			c.wmu.Unlock()
+			c.release(pk.p)
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// waitQueue waits until the packets queued so far have been written. It
+// returns early if the sender is stopped or if ctx is done.
+func (c *conn) waitQueue(ctx context.Context) {
+	if c.queue == nil {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case c.queue <- packet{done: done}:
+	case <-c.stop:
+		return
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-done:
+	case <-c.stop:
+	case <-ctx.Done():
+	}
+}
+
+// release makes p available for the next queued packet.
+func (c *conn) release(p []byte) {
+	select {
+	case c.free <- p:
+	default:
+	}
+}
+
+// close flushes the buffer, waits for the queued packets to be sent or for ctx
+// to be done and closes the connection. Only the first call has an effect.
+func (c *conn) close(ctx context.Context) {
+	if !atomic.CompareAndSwapUint32(&c.closing, 0, 1) {
+		return
+	}
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.flushAll()
+		s.closed = true
+		s.mu.Unlock()
+	}
+	if c.queue != nil {
+		c.waitQueue(ctx)
+		close(c.stop)
+	}
+
+	c.wmu.Lock()
+	n := len(c.pending)
+	// The packets still queued if ctx was done first will not be sent.
+	for queued := true; queued; {
+		select {
+		case pk := <-c.queue:
+			if pk.done == nil {
+				n++
+			}
+		default:
+			queued = false
+		}
+	}
+	if n > 0 {
+		atomic.AddUint64(&c.stats.packetsDropped, uint64(n))
+		c.collect(fmt.Errorf("statsd: %d packets could not be sent", n))
+		c.pending = nil
+	}
+	if c.w != nil {
+		err := c.w.Close()
+		c.collect(err)
+		c.handleError(err)
+	}
+	c.wClosed = true
+	c.wmu.Unlock()
+}
+
+// flushContext flushes the buffers and waits for the queued packets to be
+// written.
+func (c *conn) flushContext(ctx context.Context) error {
+	return c.wait(ctx, func() {
+		c.flushShards()
+		c.waitQueue(ctx)
+	})
+}
+
+// wait runs f in the background and waits for it to return or for ctx to be
+// done. It returns the write errors that happened meanwhile. While f runs,
+// the writes use the deadline of ctx.
+func (c *conn) wait(ctx context.Context, f func()) error {
+	deadline, _ := ctx.Deadline()
+	done := make(chan error, 1)
+	go func() {
+		c.wmu.Lock()
+		c.collecting++
+		if !deadline.IsZero() && (c.deadline.IsZero() || deadline.Before(c.deadline)) {
+			c.deadline = deadline
+		}
+		c.wmu.Unlock()
+
+		f()
+
+		c.wmu.Lock()
+		errs := c.errs
+		c.collecting--
+		if c.collecting == 0 {
+			c.errs = nil
+			if !c.deadline.IsZero() {
+				c.deadline = time.Time{}
+				if d, ok := c.w.(deadlineSetter); ok {
+					_ = d.SetWriteDeadline(time.Time{})
+				}
+			}
+		}
+		c.wmu.Unlock()
+		done <- writeErrors(errs).err()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// collect keeps err if a FlushContext or CloseContext call is running.
+// c.wmu must be held.
+func (c *conn) collect(err error) {
+	if err != nil && c.collecting > 0 {
+		c.errs = append(c.errs, err)
+	}
+}
+
+// writeErrors is the aggregated error returned by FlushContext and
+// CloseContext.
+type writeErrors []error
+
+func (e writeErrors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// Unwrap returns the aggregated errors.
+func (e writeErrors) Unwrap() []error {
+	return e
+}
+
+func (e writeErrors) err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	}
+	return e
+}
+
+// write sends p to the StatsD daemon. If the connection is down, p is kept
+// until the connection is re-established. If the connection is closed, p is
+// dropped. c.wmu must be held.
+func (c *conn) write(p []byte) {
+	if c.wClosed {
+		// The metric was sent while closing the conn.
+		atomic.AddUint64(&c.stats.packetsDropped, 1)
+		return
+	}
+	if c.w == nil {
+		c.addPending(p)
+		return
+	}
+	deadline := c.deadline
+	if c.network == "unixgram" && c.writeTimeout > 0 {
+		// A full socket buffer blocks the writes on unixgram sockets, so make
+		// sure we do not wait forever.
+		if t := now().Add(c.writeTimeout); deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	if !deadline.IsZero() {
+		if d, ok := c.w.(deadlineSetter); ok {
+			c.handleError(d.SetWriteDeadline(deadline))
+		}
+	}
+	n, err := c.w.Write(p)
+	if err == nil {
+		atomic.AddUint64(&c.stats.packetsSent, 1)
+		atomic.AddUint64(&c.stats.bytesSent, uint64(n))
+		return
+	}
+	atomic.AddUint64(&c.stats.writeErrors, 1)
+	if !isStream(c.network) && isBufferFull(err) {
+		atomic.AddUint64(&c.stats.packetsDropped, 1)
+	} else if c.canReconnect() {
+		// Part of the packet may have been written so a stream cannot be
+		// used anymore.
+		c.addPending(p)
+		c.disconnect()
+	}
+	c.collect(err)
+	c.handleError(err)
+}
+
+type deadlineSetter interface {
+	SetWriteDeadline(time.Time) error
+}
+
+// isBufferFull reports whether err means that the packet was dropped because
+// the socket buffer was full.
+func isBufferFull(err error) bool {
+	return err != nil && (errors.Is(err, syscall.ENOBUFS) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, os.ErrDeadlineExceeded))
+}
+
+func (c *conn) canReconnect() bool {
+	return c.reconnectMin > 0 && (isStream(c.network) || c.network == "unixgram")
+}
+
+// addPending keeps a copy of p. If there are already maxPending packets
+// waiting, the oldest one is dropped.
+func (c *conn) addPending(p []byte) {
+	if c.maxPending <= 0 {
+		atomic.AddUint64(&c.stats.packetsDropped, 1)
+		return
+	}
+	if len(c.pending) >= c.maxPending {
+		atomic.AddUint64(&c.stats.packetsDropped, 1)
+		copy(c.pending, c.pending[1:])
+		c.pending = c.pending[:len(c.pending)-1]
+	}
+	c.pending = append(c.pending, append([]byte(nil), p...))
+}
+
+// disconnect closes the current connection and starts reconnecting in the
+// background.
+func (c *conn) disconnect() {
+	c.handleError(c.w.Close())
+	c.w = nil
+	go c.reconnect()
+}
+
+// reconnect dials the StatsD daemon until it succeeds or the conn is closed.
+// The delay between two attempts doubles after each failure.
+func (c *conn) reconnect() {
+	delay := c.reconnectMin
+	for {
+		w, err := c.dial()
+		c.wmu.Lock()
+		if c.wClosed {
+			c.wmu.Unlock()
+			if err == nil {
+				_ = w.Close()
+			}
+			return
+		}
+		if err == nil {
+			c.w = w
+			c.flushPending()
+			c.wmu.Unlock()
+			return
+		}
+		c.handleError(err)
+		c.wmu.Unlock()
+
+		if delay <= 0 {
+			// Reconnecting is disabled, the background dial is only tried
+			// once.
+			return
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > c.reconnectMax {
+			delay = c.reconnectMax
+		}
+		if delay < c.reconnectMin {
+			delay = c.reconnectMin
+		}
+	}
+}
+
+// resolve periodically resolves host and replaces the connection when its
+// addresses differ from last, until the conn is closed.
+func (c *conn) resolve(host, last string) {
+	ticker := time.NewTicker(c.resolvePeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		if atomic.LoadUint32(&c.closing) == 1 {
+			return
+		}
+		addrs, err := c.lookup(host)
+		if err != nil {
+			c.handleError(err)
+			continue
+		}
+		if addrs == last {
+			continue
+		}
+		w, err := c.dial()
+		if err != nil {
+			c.handleError(err)
+			continue
+		}
+
+		c.wmu.Lock()
+		if closed := c.wClosed; closed || c.w == nil {
+			// The conn is closed or reconnect is already dialing the new
+			// addresses.
+			c.wmu.Unlock()
+			_ = w.Close()
+			if closed {
+				return
+			}
+			last = addrs
+			continue
+		}
+		c.handleError(c.w.Close())
+		c.w = w
+		c.wmu.Unlock()
+		last = addrs
+	}
+}
+
+// lookup returns the sorted addresses of host joined by commas.
+func (c *conn) lookup(host string) (string, error) {
+	addrs, err := c.lookupHost(host)
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ","), nil
+}
+
+// flushPending sends the packets kept while the connection was down.
+func (c *conn) flushPending() {
+	for len(c.pending) > 0 && c.w != nil {
+		p := c.pending[0]
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+		c.write(p)
+	}
+}
+
+func (c *conn) dial() (io.WriteCloser, error) {
+	return dialTimeout(c.network, c.addr, c.dialTimeout)
+}
+
+// isStream reports whether network is a stream-oriented network.
+func isStream(network string) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return true
+	}
+	return false
 }
 
 func (c *conn) handleError(err error) {
 	if err != nil && c.errorHandler != nil {
+		c.owner.errMu.Lock()
 		c.errorHandler(err)
+		c.owner.errMu.Unlock()
 	}
 }
 
 // Stubbed out for testing.
 var (
 	dialTimeout = net.DialTimeout
+	lookupHost  = net.LookupHost
 	now         = time.Now
 	randFloat   = rand.Float32
 )
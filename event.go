@@ -0,0 +1,199 @@
+package statsd
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Event sends an event with the given title and text. Events are only
+// supported by DogStatsD.
+//
+// The Client's tags are sent with the event if the Client uses the Datadog
+// tag format. Events are not sampled. Newlines in the title and the text are
+// escaped.
+func (c *Client) Event(title, text string, opts ...EventOption) {
+	if c.muted || c.isClosed() {
+		return
+	}
+	e := &event{
+		title: strings.Replace(title, "\n", "\\n", -1),
+		text:  strings.Replace(text, "\n", "\\n", -1),
+	}
+	for _, o := range opts {
+		o(e)
+	}
+	c.conn.sanitizeTags(e.tags)
+	c.conn.event(e, c.tags)
+}
+
+// An EventOption represents an option for an event. It must be used as an
+// argument to Client.Event().
+type EventOption func(*event)
+
+type event struct {
+	title          string
+	text           string
+	timestamp      time.Time
+	hostname       string
+	aggregationKey string
+	priority       EventPriority
+	sourceType     string
+	alertType      EventAlertType
+	tags           []tag
+}
+
+// EventPriority represents the priority of an event.
+type EventPriority string
+
+const (
+	// PriorityNormal is the normal priority of an event.
+	PriorityNormal EventPriority = "normal"
+	// PriorityLow is the low priority of an event.
+	PriorityLow EventPriority = "low"
+)
+
+// EventAlertType represents the alert type of an event.
+type EventAlertType string
+
+const (
+	// AlertInfo is the info alert type.
+	AlertInfo EventAlertType = "info"
+	// AlertWarning is the warning alert type.
+	AlertWarning EventAlertType = "warning"
+	// AlertError is the error alert type.
+	AlertError EventAlertType = "error"
+	// AlertSuccess is the success alert type.
+	AlertSuccess EventAlertType = "success"
+)
+
+// EventTimestamp sets the date of the event.
+//
+// By default, DogStatsD uses the date at which it received the event.
+func EventTimestamp(t time.Time) EventOption {
+	return EventOption(func(e *event) {
+		e.timestamp = t
+	})
+}
+
+// EventHostname sets the name of the host associated with the event.
+func EventHostname(h string) EventOption {
+	return EventOption(func(e *event) {
+		e.hostname = h
+	})
+}
+
+// EventAggregationKey sets the key used to group the event with others.
+func EventAggregationKey(k string) EventOption {
+	return EventOption(func(e *event) {
+		e.aggregationKey = k
+	})
+}
+
+// EventPriorityLevel sets the priority of the event.
+//
+// By default, the priority is normal.
+func EventPriorityLevel(p EventPriority) EventOption {
+	return EventOption(func(e *event) {
+		e.priority = p
+	})
+}
+
+// EventSourceType sets the source type of the event (e.g. "nagios").
+func EventSourceType(s string) EventOption {
+	return EventOption(func(e *event) {
+		e.sourceType = s
+	})
+}
+
+// EventAlert sets the alert type of the event.
+//
+// By default, the alert type is info.
+func EventAlert(t EventAlertType) EventOption {
+	return EventOption(func(e *event) {
+		e.alertType = t
+	})
+}
+
+// EventTags appends the given tags to the tags sent with the event.
+//
+// The tags must be set as key-value pairs. If the number of tags is not even,
+// EventTags panics.
+func EventTags(tags ...string) EventOption {
+	if len(tags)%2 != 0 {
+		panic("statsd: EventTags only accepts an even number of arguments")
+	}
+
+	return EventOption(func(e *event) {
+		for i := 0; i < len(tags); i += 2 {
+			e.tags = append(e.tags, tag{K: tags[i], V: tags[i+1]})
+		}
+	})
+}
+
+// event appends an event using the DogStatsD datagram format:
+// _e{title.length,text.length}:title|text|d:timestamp|h:hostname|k:key|
+// p:priority|s:source_type|t:alert_type|#tag1:value1,tag2:value2
+func (c *conn) event(e *event, tags string) {
+	s := c.shard("", e.title, tags)
+	atomic.AddUint64(&s.stats.metricsQueued, 1)
+	s.mu.Lock()
+	s.appendEvent(e, tags)
+	s.mu.Unlock()
+}
+
+func (c *conn) appendEvent(e *event, tags string) {
+	l := len(c.buf)
+	c.appendString("_e{")
+	c.buf = strconv.AppendInt(c.buf, int64(len(e.title)), 10)
+	c.appendByte(',')
+	c.buf = strconv.AppendInt(c.buf, int64(len(e.text)), 10)
+	c.appendString("}:")
+	c.appendString(e.title)
+	c.appendByte('|')
+	c.appendString(e.text)
+	if !e.timestamp.IsZero() {
+		c.appendString("|d:")
+		c.buf = strconv.AppendInt(c.buf, e.timestamp.Unix(), 10)
+	}
+	c.appendField("h", e.hostname)
+	c.appendField("k", e.aggregationKey)
+	c.appendField("p", string(e.priority))
+	c.appendField("s", e.sourceType)
+	c.appendField("t", string(e.alertType))
+	c.appendDatadogTags(tags, e.tags)
+	c.appendByte('\n')
+	c.flushIfBufferFull(l)
+}
+
+// appendField appends the |name:value field if value is not empty.
+func (c *conn) appendField(name, value string) {
+	if value == "" {
+		return
+	}
+	c.appendByte('|')
+	c.appendString(name)
+	c.appendByte(':')
+	c.appendString(value)
+}
+
+// appendDatadogTags appends the Client's tags followed by extra tags using the
+// Datadog format. The Client's tags are ignored if another format is used.
+func (c *conn) appendDatadogTags(tags string, extra []tag) {
+	if c.tagFormat == Datadog {
+		c.appendString(tags)
+	} else {
+		tags = ""
+	}
+	for i, t := range extra {
+		if i == 0 && tags == "" {
+			c.appendString("|#")
+		} else {
+			c.appendByte(',')
+		}
+		c.appendString(t.K)
+		c.appendByte(':')
+		c.appendString(t.V)
+	}
+}
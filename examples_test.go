@@ -1,8 +1,10 @@
 package statsd_test
 
 import (
+	"context"
 	"log"
 	"runtime"
+	"strings"
 	"time"
 
 	"gopkg.in/alexcesaro/statsd.v2"
@@ -84,10 +86,75 @@ func ExampleNetwork() {
 	c, err = statsd.New(statsd.Network("tcp"))
 }
 
+func ExampleNetwork_unixgram() {
+	// Send metrics to a DogStatsD agent listening on a Unix domain socket.
+	c, err = statsd.New(
+		statsd.Network("unixgram"),
+		statsd.Address("/var/run/datadog/dsd.socket"),
+	)
+}
+
+func ExampleReconnectBackoff() {
+	// Try to reconnect every 50ms at first, then back off up to every minute.
+	c, err = statsd.New(
+		statsd.Network("tcp"),
+		statsd.ReconnectBackoff(50*time.Millisecond, time.Minute),
+	)
+}
+
 func ExampleTagsFormat() {
 	c, err = statsd.New(statsd.TagsFormat(statsd.InfluxDB))
 }
 
+func ExampleAggregate() {
+	c, err := statsd.New(statsd.Aggregate(true))
+	if err != nil {
+		log.Print(err)
+	}
+	for i := 0; i < 1000; i++ {
+		c.Increment("foo.bar") // Sends foo.bar:1000|c when flushed.
+	}
+}
+
+// pipeFormat formats tags as |tag1=value1|tag2=value2 at the end of metrics.
+type pipeFormat struct{}
+
+func (pipeFormat) Join(tags []string) string {
+	s := ""
+	for i := 0; i < len(tags); i += 2 {
+		s += "|" + tags[i] + "=" + tags[i+1]
+	}
+	return s
+}
+
+func (pipeFormat) Split(s string) []string {
+	var tags []string
+	for _, pair := range strings.Split(s[1:], "|") {
+		tags = append(tags, strings.SplitN(pair, "=", 2)...)
+	}
+	return tags
+}
+
+func (pipeFormat) Placement() statsd.TagPlacement {
+	return statsd.TagsAfterType
+}
+
+func ExampleTagFormatter() {
+	c, err = statsd.New(
+		statsd.TagsFormat(pipeFormat{}),
+		statsd.Tags("region", "us"),
+	)
+	c.Increment("foo.bar") // Sends foo.bar:1|c|region=us
+}
+
+func ExampleSanitize() {
+	c, err := statsd.New(statsd.Sanitize(statsd.SanitizeReplace))
+	if err != nil {
+		log.Print(err)
+	}
+	c.Increment("http:200") // Increments: http_200
+}
+
 func ExampleMute() {
 	c, err := statsd.New(statsd.Mute(true))
 	if err != nil {
@@ -115,8 +182,178 @@ func ExampleTags() {
 	)
 }
 
+func ExampleClient_Count() {
+	c, err := statsd.New(
+		statsd.TagsFormat(statsd.Datadog),
+		statsd.Tags("app", "my_app"),
+	)
+	if err != nil {
+		log.Print(err)
+	}
+	// Increments: requests with the tags app:my_app and status:500
+	c.Count("requests", 1, "status", "500")
+}
+
+func ExampleRemoveTags() {
+	c, err := statsd.New(
+		statsd.TagsFormat(statsd.Datadog),
+		statsd.Tags("env", "prod", "region", "us"),
+	)
+	if err != nil {
+		log.Print(err)
+	}
+
+	global := c.Clone(statsd.RemoveTags("region"))
+	global.Increment("foo.bar") // Increments foo.bar with the tag env:prod only.
+}
+
+func ExampleClient_NewCounter() {
+	requests := c.NewCounter("http.requests", "method", "GET")
+	for i := 0; i < 10; i++ {
+		requests.Increment()
+	}
+}
+
+func ExampleClient_Stats() {
+	s := c.Stats()
+	log.Printf("%d metrics queued, %d packets sent, %d packets dropped",
+		s.MetricsQueued, s.PacketsSent, s.PacketsDropped)
+}
+
+func ExampleAsyncQueue() {
+	// Send the packets in the background so that a slow StatsD daemon never
+	// blocks the application. Packets are dropped when 64 of them are already
+	// waiting to be sent.
+	c, err := statsd.New(
+		statsd.Network("tcp"),
+		statsd.AsyncQueue(64, statsd.DropWhenFull),
+	)
+	if err != nil {
+		log.Print(err)
+	}
+	defer c.Close()
+
+	c.Increment("foo.counter")
+}
+
+func ExampleBufferShards() {
+	// Spread the metrics across 8 buffers to reduce the lock contention when
+	// many goroutines send metrics.
+	c, err := statsd.New(statsd.BufferShards(8))
+	if err != nil {
+		log.Print(err)
+	}
+	defer c.Close()
+
+	c.Increment("foo.counter")
+}
+
+func ExampleClient_CloseContext() {
+	// Do not wait more than 5 seconds for the last metrics to be sent when the
+	// application stops.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.CloseContext(ctx); err != nil {
+		log.Print(err)
+	}
+}
+
+func ExampleCloseConnection() {
+	c, err := statsd.New()
+	if err != nil {
+		log.Print(err)
+	}
+
+	// Closing worker only flushes the buffer, c can still be used.
+	worker := c.Clone(statsd.Prefix("worker"))
+	worker.Increment("jobs")
+	worker.Close()
+
+	// Closing stop also closes the connection shared with c.
+	stop := c.Clone(statsd.CloseConnection(true))
+	stop.Close()
+}
+
+func ExampleNewMultiClient() {
+	// Send the metrics both to a Graphite StatsD and to a DogStatsD agent.
+	graphite, err := statsd.New(
+		statsd.Address("graphite:8125"),
+		statsd.TagsFormat(statsd.Graphite),
+	)
+	if err != nil {
+		log.Print(err)
+	}
+	datadog, err := statsd.New(
+		statsd.Address("localhost:8125"),
+		statsd.TagsFormat(statsd.Datadog),
+	)
+	if err != nil {
+		log.Print(err)
+	}
+
+	c := statsd.NewMultiClient(graphite, datadog)
+	defer c.Close()
+
+	c.Increment("foo.counter", "region", "us-east-1")
+}
+
+func ExampleNewClusterClient() {
+	// Each bucket is always sent to the same StatsD node.
+	c, err := statsd.NewClusterClient(
+		[]string{"statsd1:8125", "statsd2:8125", "statsd3:8125"},
+		statsd.Prefix("my_app"),
+	)
+	if err != nil {
+		log.Print(err)
+	}
+	defer c.Close()
+
+	c.Increment("foo.counter")
+}
+
+func ExampleResolvePeriod() {
+	// Follow the IP address of the StatsD service when it changes.
+	c, err := statsd.New(
+		statsd.Address("statsd.default.svc.cluster.local:8125"),
+		statsd.ResolvePeriod(30*time.Second),
+	)
+	if err != nil {
+		log.Print(err)
+	}
+	defer c.Close()
+
+	c.Increment("foo.counter")
+}
+
+func ExampleBackgroundDial() {
+	// The application may start before the local agent: New does not fail
+	// and the metrics are sent once the agent is reachable.
+	c, _ := statsd.New(
+		statsd.Network("unixgram"),
+		statsd.Address("/var/run/datadog/dsd.socket"),
+		statsd.BackgroundDial(true),
+		statsd.DialTimeout(time.Second),
+	)
+	defer c.Close()
+
+	c.Increment("foo.counter")
+}
+
 func ExampleClient_NewTiming() {
 	// Send a timing metric each time the function is run.
 	defer c.NewTiming().Send("homepage.response_time")
 	ping("http://example.com/")
 }
+
+func ExampleClient_Event() {
+	c.Event("Deploy", "Version 1.2.3 deployed",
+		statsd.EventAlert(statsd.AlertSuccess),
+		statsd.EventTags("version", "1.2.3"),
+	)
+}
+
+func ExampleClient_ServiceCheck() {
+	c.ServiceCheck("db.replication", statsd.StatusWarning,
+		statsd.ServiceCheckMessage("Replication lag is above 10s"),
+	)
+}
@@ -0,0 +1,210 @@
+package statsd
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// A line is a metric whose bucket, tags and type are rendered once so that
+// only the value needs to be appended when sending it.
+type line struct {
+	prefix, bucket, typ, tags string
+	rate                      float32
+	// head is everything before the value and tail everything after it.
+	head, tail []byte
+}
+
+func (c *conn) newLine(prefix, bucket, typ string, rate float32, tags string) *line {
+	l := &line{
+		prefix: prefix,
+		bucket: c.sanitize(bucket, c.bucketReserved),
+		typ:    typ,
+		rate:   rate,
+		tags:   tags,
+	}
+
+	c.mu.Lock()
+	n := len(c.buf)
+	c.appendBucket(l.prefix, l.bucket, tags)
+	l.head = append([]byte(nil), c.buf[n:]...)
+	c.buf = c.buf[:n]
+	c.appendType(typ)
+	c.appendRate(rate)
+	c.closeMetric(tags)
+	l.tail = append([]byte(nil), c.buf[n:]...)
+	c.buf = c.buf[:n]
+	c.mu.Unlock()
+	return l
+}
+
+// appendLine appends the value n of the metric l.
+func (c *conn) appendLine(l *line, n number) {
+	c.buf = append(c.buf, l.head...)
+	c.appendNumber(n)
+	c.buf = append(c.buf, l.tail...)
+}
+
+func (c *conn) lineMetric(l *line, n number) {
+	s := c.shard(l.prefix, l.bucket, l.tags)
+	atomic.AddUint64(&s.stats.metricsQueued, 1)
+	if c.agg != nil && c.agg.metric(l.prefix, l.bucket, n, l.typ, l.rate, l.tags) {
+		return
+	}
+	s.mu.Lock()
+	start := len(s.buf)
+	s.appendLine(l, n)
+	s.flushIfBufferFull(start)
+	s.mu.Unlock()
+}
+
+func (c *conn) lineGauge(l *line, value number) {
+	s := c.shard(l.prefix, l.bucket, l.tags)
+	atomic.AddUint64(&s.stats.metricsQueued, 1)
+	if c.agg != nil && c.agg.gauge(l.prefix, l.bucket, value, l.tags) {
+		return
+	}
+	s.mu.Lock()
+	start := len(s.buf)
+	// To set a gauge to a negative value we must first set it to 0.
+	if value.isNegative() {
+		s.appendLine(l, intValue(0))
+	}
+	s.appendLine(l, value)
+	s.flushIfBufferFull(start)
+	s.mu.Unlock()
+}
+
+func (c *conn) lineUnique(l *line, value string) {
+	s := c.shard(l.prefix, l.bucket, l.tags)
+	atomic.AddUint64(&s.stats.metricsQueued, 1)
+	value = c.sanitize(value, valueReserved)
+	if c.agg != nil && c.agg.unique(l.prefix, l.bucket, value, l.tags) {
+		return
+	}
+	s.mu.Lock()
+	start := len(s.buf)
+	s.buf = append(s.buf, l.head...)
+	s.appendString(value)
+	s.buf = append(s.buf, l.tail...)
+	s.flushIfBufferFull(start)
+	s.mu.Unlock()
+}
+
+// A Counter is a counter bound to a bucket and tags. It is cheaper than
+// calling Client.Count since the bucket and the tags are rendered once.
+type Counter struct {
+	c *Client
+	l *line
+}
+
+// NewCounter returns a Counter for the given bucket and tags. It inherits the
+// prefix, the tags, the sample rate and the mute state of the Client.
+func (c *Client) NewCounter(bucket string, tags ...string) Counter {
+	return Counter{c: c, l: c.conn.newLine(c.prefix, bucket, "c", c.rate, c.withTags(tags))}
+}
+
+// Add adds n to the counter.
+func (h Counter) Add(n int64) {
+	if h.c.skip() {
+		return
+	}
+	h.c.conn.lineMetric(h.l, intValue(n))
+}
+
+// Increment increments the counter. It is equivalent to Add(1).
+func (h Counter) Increment() {
+	h.Add(1)
+}
+
+// A Gauge is a gauge bound to a bucket and tags. It is cheaper than calling
+// Client.Gauge since the bucket and the tags are rendered once.
+type Gauge struct {
+	c *Client
+	l *line
+}
+
+// NewGauge returns a Gauge for the given bucket and tags. It inherits the
+// prefix, the tags, the sample rate and the mute state of the Client.
+func (c *Client) NewGauge(bucket string, tags ...string) Gauge {
+	return Gauge{c: c, l: c.conn.newLine(c.prefix, bucket, "g", 1, c.withTags(tags))}
+}
+
+// Set records an absolute value for the gauge.
+func (h Gauge) Set(value int64) {
+	if h.c.skip() {
+		return
+	}
+	h.c.conn.lineGauge(h.l, intValue(value))
+}
+
+// SetFloat64 records an absolute value for the gauge.
+func (h Gauge) SetFloat64(value float64) {
+	if h.c.skip() {
+		return
+	}
+	h.c.conn.lineGauge(h.l, float64Value(value))
+}
+
+// A Timer sends timing values to a bucket with the given tags. It is cheaper
+// than calling Client.Timing since the bucket and the tags are rendered once.
+type Timer struct {
+	c *Client
+	l *line
+}
+
+// NewTimer returns a Timer for the given bucket and tags. It inherits the
+// prefix, the tags, the sample rate and the mute state of the Client.
+func (c *Client) NewTimer(bucket string, tags ...string) Timer {
+	return Timer{c: c, l: c.conn.newLine(c.prefix, bucket, "ms", c.rate, c.withTags(tags))}
+}
+
+// Record sends d as a timing value in milliseconds.
+func (h Timer) Record(d time.Duration) {
+	if h.c.skip() {
+		return
+	}
+	h.c.conn.lineMetric(h.l, float64Value(float64(d)/float64(time.Millisecond)))
+}
+
+// A Histogram sends histogram values to a bucket with the given tags. It is
+// cheaper than calling Client.Histogram since the bucket and the tags are
+// rendered once.
+type Histogram struct {
+	c *Client
+	l *line
+}
+
+// NewHistogram returns a Histogram for the given bucket and tags. It inherits
+// the prefix, the tags, the sample rate and the mute state of the Client.
+func (c *Client) NewHistogram(bucket string, tags ...string) Histogram {
+	return Histogram{c: c, l: c.conn.newLine(c.prefix, bucket, "h", c.rate, c.withTags(tags))}
+}
+
+// Record sends an histogram value.
+func (h Histogram) Record(value float64) {
+	if h.c.skip() {
+		return
+	}
+	h.c.conn.lineMetric(h.l, float64Value(value))
+}
+
+// A Set sends values to a set bucket with the given tags. It is cheaper than
+// calling Client.Unique since the bucket and the tags are rendered once.
+type Set struct {
+	c *Client
+	l *line
+}
+
+// NewSet returns a Set for the given bucket and tags. It inherits the prefix,
+// the tags, the sample rate and the mute state of the Client.
+func (c *Client) NewSet(bucket string, tags ...string) Set {
+	return Set{c: c, l: c.conn.newLine(c.prefix, bucket, "s", 1, c.withTags(tags))}
+}
+
+// Add sends the given value to the set.
+func (h Set) Add(value string) {
+	if h.c.skip() {
+		return
+	}
+	h.c.conn.lineUnique(h.l, value)
+}
@@ -0,0 +1,177 @@
+package statsd
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A MultiClient sends every metric to several StatsD daemons. Each destination
+// is a Client with its own configuration (network, tag format, packet size,
+// ErrorHandler, etc.) so the errors are reported per destination.
+type MultiClient struct {
+	clients []*Client
+}
+
+// NewMultiClient returns a MultiClient sending the metrics to all the given
+// Clients. Closing the MultiClient closes them.
+func NewMultiClient(clients ...*Client) *MultiClient {
+	return &MultiClient{clients: clients}
+}
+
+// Clients returns the Clients of m, one per destination.
+func (m *MultiClient) Clients() []*Client {
+	return m.clients
+}
+
+// Clone returns a MultiClient whose Clients are clones of the Clients of m
+// created with the given options.
+func (m *MultiClient) Clone(opts ...Option) *MultiClient {
+	clients := make([]*Client, len(m.clients))
+	for i, c := range m.clients {
+		clients[i] = c.Clone(opts...)
+	}
+	return &MultiClient{clients: clients}
+}
+
+// Count adds n to bucket on all the destinations.
+func (m *MultiClient) Count(bucket string, n interface{}, tags ...string) {
+	for _, c := range m.clients {
+		c.Count(bucket, n, tags...)
+	}
+}
+
+// Increment increment the given bucket on all the destinations.
+func (m *MultiClient) Increment(bucket string, tags ...string) {
+	for _, c := range m.clients {
+		c.Increment(bucket, tags...)
+	}
+}
+
+// Gauge records an absolute value for the given bucket on all the
+// destinations.
+func (m *MultiClient) Gauge(bucket string, value interface{}, tags ...string) {
+	for _, c := range m.clients {
+		c.Gauge(bucket, value, tags...)
+	}
+}
+
+// Timing sends a timing value to a bucket on all the destinations.
+func (m *MultiClient) Timing(bucket string, value interface{}, tags ...string) {
+	for _, c := range m.clients {
+		c.Timing(bucket, value, tags...)
+	}
+}
+
+// TimingDuration sends d to a bucket as a timing value in milliseconds on all
+// the destinations.
+func (m *MultiClient) TimingDuration(bucket string, d time.Duration, tags ...string) {
+	for _, c := range m.clients {
+		c.TimingDuration(bucket, d, tags...)
+	}
+}
+
+// Histogram sends an histogram value to a bucket on all the destinations.
+func (m *MultiClient) Histogram(bucket string, value interface{}, tags ...string) {
+	for _, c := range m.clients {
+		c.Histogram(bucket, value, tags...)
+	}
+}
+
+// Distribution sends a distribution value to a bucket on all the
+// destinations.
+func (m *MultiClient) Distribution(bucket string, value interface{}, tags ...string) {
+	for _, c := range m.clients {
+		c.Distribution(bucket, value, tags...)
+	}
+}
+
+// Unique sends the given value to a set bucket on all the destinations.
+func (m *MultiClient) Unique(bucket string, value string, tags ...string) {
+	for _, c := range m.clients {
+		c.Unique(bucket, value, tags...)
+	}
+}
+
+// Event sends an event to all the destinations.
+func (m *MultiClient) Event(title, text string, opts ...EventOption) {
+	for _, c := range m.clients {
+		c.Event(title, text, opts...)
+	}
+}
+
+// ServiceCheck sends the status of a service to all the destinations.
+func (m *MultiClient) ServiceCheck(name string, status ServiceCheckStatus, opts ...ServiceCheckOption) {
+	for _, c := range m.clients {
+		c.ServiceCheck(name, status, opts...)
+	}
+}
+
+// Flush flushes the buffers of all the destinations.
+func (m *MultiClient) Flush() {
+	for _, c := range m.clients {
+		c.Flush()
+	}
+}
+
+// FlushContext is like Client.FlushContext. The errors of each destination are
+// returned as DestinationErrors.
+func (m *MultiClient) FlushContext(ctx context.Context) error {
+	return m.each(func(c *Client) error {
+		return c.FlushContext(ctx)
+	})
+}
+
+// Close closes all the destinations.
+func (m *MultiClient) Close() {
+	for _, c := range m.clients {
+		c.Close()
+	}
+}
+
+// CloseContext is like Client.CloseContext. The errors of each destination are
+// returned as DestinationErrors.
+func (m *MultiClient) CloseContext(ctx context.Context) error {
+	return m.each(func(c *Client) error {
+		return c.CloseContext(ctx)
+	})
+}
+
+// each calls f on all the Clients concurrently and aggregates the errors.
+func (m *MultiClient) each(f func(*Client) error) error {
+	results := make([]error, len(m.clients))
+	var wg sync.WaitGroup
+	for i, c := range m.clients {
+		wg.Add(1)
+		go func(i int, c *Client) {
+			results[i] = f(c)
+			wg.Done()
+		}(i, c)
+	}
+	wg.Wait()
+
+	var errs writeErrors
+	for i, err := range results {
+		if err != nil {
+			errs = append(errs, &DestinationError{Addr: m.clients[i].conn.addr, Err: err})
+		}
+	}
+	return errs.err()
+}
+
+// A DestinationError is an error returned by a MultiClient for one of its
+// destinations.
+type DestinationError struct {
+	// Addr is the address of the destination.
+	Addr string
+	Err  error
+}
+
+func (e *DestinationError) Error() string {
+	return "statsd: " + e.Addr + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *DestinationError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,221 @@
+package statsd
+
+import (
+	"fmt"
+	"time"
+)
+
+// A number is the value of a metric. Unlike an interface{}, passing a number
+// does not allocate.
+type number struct {
+	kind numberKind
+	i    int64
+	u    uint64
+	f    float64
+}
+
+type numberKind uint8
+
+const (
+	kindInt numberKind = iota
+	kindUint
+	kindFloat64
+	kindFloat32
+)
+
+func intValue(n int64) number {
+	return number{kind: kindInt, i: n}
+}
+
+func uintValue(n uint64) number {
+	return number{kind: kindUint, u: n}
+}
+
+func float64Value(f float64) number {
+	return number{kind: kindFloat64, f: f}
+}
+
+func float32Value(f float32) number {
+	return number{kind: kindFloat32, f: float64(f)}
+}
+
+func (n number) isNegative() bool {
+	switch n.kind {
+	case kindInt:
+		return n.i < 0
+	case kindUint:
+		return false
+	}
+	return n.f < 0
+}
+
+func (n number) float64() float64 {
+	switch n.kind {
+	case kindInt:
+		return float64(n.i)
+	case kindUint:
+		return float64(n.u)
+	}
+	return n.f
+}
+
+// add returns the sum of n and m. The sum stays an integer unless one of them
+// is a float so that large counters keep their exact value.
+func (n number) add(m number) number {
+	if n.kind >= kindFloat64 || m.kind >= kindFloat64 {
+		return float64Value(n.float64() + m.float64())
+	}
+	if (n.kind == kindUint || m.kind == kindUint) && !n.isNegative() && !m.isNegative() {
+		return uintValue(n.uint64() + m.uint64())
+	}
+	return intValue(n.int64() + m.int64())
+}
+
+func (n number) int64() int64 {
+	if n.kind == kindUint {
+		return int64(n.u)
+	}
+	return n.i
+}
+
+func (n number) uint64() uint64 {
+	if n.kind == kindUint {
+		return n.u
+	}
+	return uint64(n.i)
+}
+
+// toNumber converts v to a number. It returns false if v is neither an integer
+// nor a float.
+func toNumber(v interface{}) (number, bool) {
+	switch n := v.(type) {
+	case int:
+		return intValue(int64(n)), true
+	case uint:
+		return uintValue(uint64(n)), true
+	case int64:
+		return intValue(n), true
+	case uint64:
+		return uintValue(n), true
+	case int32:
+		return intValue(int64(n)), true
+	case uint32:
+		return uintValue(uint64(n)), true
+	case int16:
+		return intValue(int64(n)), true
+	case uint16:
+		return uintValue(uint64(n)), true
+	case int8:
+		return intValue(int64(n)), true
+	case uint8:
+		return uintValue(uint64(n)), true
+	case float64:
+		return float64Value(n), true
+	case float32:
+		return float32Value(n), true
+	}
+	return number{}, false
+}
+
+// number converts v to a number. If v is not a number, the error is reported
+// to the ErrorHandler and false is returned.
+func (c *conn) number(v interface{}) (number, bool) {
+	n, ok := toNumber(v)
+	if !ok {
+		c.handleError(fmt.Errorf("statsd: unsupported value type %T", v))
+	}
+	return n, ok
+}
+
+// CountInt64 adds n to bucket. Unlike Count, it does not allocate.
+func (c *Client) CountInt64(bucket string, n int64, tags ...string) {
+	if c.skip() {
+		return
+	}
+	c.conn.metric(c.prefix, bucket, intValue(n), "c", c.rate, c.withTags(tags))
+}
+
+// CountFloat64 adds n to bucket. Unlike Count, it does not allocate.
+func (c *Client) CountFloat64(bucket string, n float64, tags ...string) {
+	if c.skip() {
+		return
+	}
+	c.conn.metric(c.prefix, bucket, float64Value(n), "c", c.rate, c.withTags(tags))
+}
+
+// GaugeInt64 records an absolute value for the given bucket. Unlike Gauge, it
+// does not allocate.
+func (c *Client) GaugeInt64(bucket string, value int64, tags ...string) {
+	if c.skip() {
+		return
+	}
+	c.conn.gauge(c.prefix, bucket, intValue(value), c.withTags(tags))
+}
+
+// GaugeFloat64 records an absolute value for the given bucket. Unlike Gauge, it
+// does not allocate.
+func (c *Client) GaugeFloat64(bucket string, value float64, tags ...string) {
+	if c.skip() {
+		return
+	}
+	c.conn.gauge(c.prefix, bucket, float64Value(value), c.withTags(tags))
+}
+
+// TimingInt64 sends a timing value in milliseconds to a bucket. Unlike Timing,
+// it does not allocate.
+func (c *Client) TimingInt64(bucket string, value int64, tags ...string) {
+	if c.skip() {
+		return
+	}
+	c.conn.metric(c.prefix, bucket, intValue(value), "ms", c.rate, c.withTags(tags))
+}
+
+// TimingFloat64 sends a timing value in milliseconds to a bucket. Unlike
+// Timing, it does not allocate.
+func (c *Client) TimingFloat64(bucket string, value float64, tags ...string) {
+	if c.skip() {
+		return
+	}
+	c.conn.metric(c.prefix, bucket, float64Value(value), "ms", c.rate, c.withTags(tags))
+}
+
+// TimingDuration sends d to a bucket as a timing value in milliseconds.
+func (c *Client) TimingDuration(bucket string, d time.Duration, tags ...string) {
+	c.TimingFloat64(bucket, float64(d)/float64(time.Millisecond), tags...)
+}
+
+// HistogramInt64 sends an histogram value to a bucket. Unlike Histogram, it
+// does not allocate.
+func (c *Client) HistogramInt64(bucket string, value int64, tags ...string) {
+	if c.skip() {
+		return
+	}
+	c.conn.metric(c.prefix, bucket, intValue(value), "h", c.rate, c.withTags(tags))
+}
+
+// HistogramFloat64 sends an histogram value to a bucket. Unlike Histogram, it
+// does not allocate.
+func (c *Client) HistogramFloat64(bucket string, value float64, tags ...string) {
+	if c.skip() {
+		return
+	}
+	c.conn.metric(c.prefix, bucket, float64Value(value), "h", c.rate, c.withTags(tags))
+}
+
+// DistributionInt64 sends a distribution value to a bucket. Unlike
+// Distribution, it does not allocate.
+func (c *Client) DistributionInt64(bucket string, value int64, tags ...string) {
+	if c.skip() {
+		return
+	}
+	c.conn.metric(c.prefix, bucket, intValue(value), "d", c.rate, c.withTags(tags))
+}
+
+// DistributionFloat64 sends a distribution value to a bucket. Unlike
+// Distribution, it does not allocate.
+func (c *Client) DistributionFloat64(bucket string, value float64, tags ...string) {
+	if c.skip() {
+		return
+	}
+	c.conn.metric(c.prefix, bucket, float64Value(value), "d", c.rate, c.withTags(tags))
+}
@@ -12,19 +12,35 @@ type config struct {
 }
 
 type clientConfig struct {
-	Muted  bool
-	Rate   float32
-	Prefix string
-	Tags   []tag
+	Muted     bool
+	Rate      float32
+	Prefix    string
+	Tags      []tag
+	CloseConn bool
+	HashTags  bool
 }
 
 type connConfig struct {
-	Addr          string
-	ErrorHandler  func(error)
-	FlushPeriod   time.Duration
-	MaxPacketSize int
-	Network       string
-	TagFormat     TagFormat
+	Addr              string
+	ErrorHandler      func(error)
+	FlushPeriod       time.Duration
+	MaxPacketSize     int
+	Network           string
+	TagFormat         TagFormatter
+	DialTimeout       time.Duration
+	BackgroundDial    bool
+	ReconnectMin      time.Duration
+	ReconnectMax      time.Duration
+	MaxPending        int
+	WriteTimeout      time.Duration
+	Aggregate         bool
+	ExtendedAggregate bool
+	SanitizeMode      SanitizeMode
+	Telemetry         time.Duration
+	ResolvePeriod     time.Duration
+	QueueSize         int
+	QueuePolicy       QueuePolicy
+	Shards            int
 }
 
 // An Option represents an option for a Client. It must be used as an
@@ -65,17 +81,18 @@ func FlushPeriod(p time.Duration) Option {
 
 // MaxPacketSize sets the maximum packet size in bytes sent by the Client.
 //
-// By default, it is 1440 to avoid IP fragmentation. This option is ignored in
-// Client.Clone().
+// By default, it is 1440 to avoid IP fragmentation, or 8192 when using a Unix
+// domain socket. This option is ignored in Client.Clone().
 func MaxPacketSize(n int) Option {
 	return Option(func(c *config) {
 		c.Conn.MaxPacketSize = n
 	})
 }
 
-// Network sets the network (udp, tcp, etc) used by the client. See the
-// net.Dial documentation (https://golang.org/pkg/net/#Dial) for the available
-// network options.
+// Network sets the network (udp, tcp, unixgram, unix, etc) used by the client.
+// See the net.Dial documentation (https://golang.org/pkg/net/#Dial) for the
+// available network options. When using a Unix domain socket, the address is
+// the path of the socket.
 //
 // By default, network is udp. This option is ignored in Client.Clone().
 func Network(network string) Option {
@@ -84,6 +101,173 @@ func Network(network string) Option {
 	})
 }
 
+// DialTimeout sets the maximum amount of time spent dialing the StatsD daemon.
+//
+// By default, the timeout is 5 seconds. This option is ignored in
+// Client.Clone().
+func DialTimeout(d time.Duration) Option {
+	return Option(func(c *config) {
+		c.Conn.DialTimeout = d
+	})
+}
+
+// BackgroundDial sets whether the StatsD daemon is dialed in the background.
+// If b is true, New never fails: the connection is established by a
+// goroutine that retries with the delays set by ReconnectBackoff, and the
+// packets sent meanwhile are kept within the limit set by MaxPendingPackets.
+//
+// By default, New dials the StatsD daemon and returns a muted Client if it
+// fails. This option is ignored in Client.Clone().
+func BackgroundDial(b bool) Option {
+	return Option(func(c *config) {
+		c.Conn.BackgroundDial = b
+	})
+}
+
+// ReconnectBackoff sets the delays between two attempts to reconnect to the
+// StatsD daemon when a write fails on a stream connection (e.g. tcp). The delay
+// starts at min and doubles after each failed attempt until it reaches max. If
+// max is lower than min, the delay stays at min. If min is 0, the Client does
+// not try to reconnect.
+//
+// By default, min is 100 ms and max is 10 s. This option is ignored in
+// Client.Clone().
+func ReconnectBackoff(min, max time.Duration) Option {
+	return Option(func(c *config) {
+		c.Conn.ReconnectMin = min
+		c.Conn.ReconnectMax = max
+	})
+}
+
+// MaxPendingPackets sets the maximum number of packets kept in memory while the
+// Client is not connected to the StatsD daemon. When this limit is reached, the
+// oldest packets are dropped.
+//
+// By default, 64 packets are kept. This option is ignored in Client.Clone().
+func MaxPendingPackets(n int) Option {
+	return Option(func(c *config) {
+		c.Conn.MaxPending = n
+	})
+}
+
+// WriteTimeout sets the maximum time spent writing a packet on a unixgram
+// socket. When the socket buffer is full, the packet is dropped instead of
+// blocking the Client. If d is 0, writes block until the buffer is drained.
+//
+// By default, the timeout is 100 ms. This option is ignored in Client.Clone().
+func WriteTimeout(d time.Duration) Option {
+	return Option(func(c *config) {
+		c.Conn.WriteTimeout = d
+	})
+}
+
+// Aggregate sets whether the Client aggregates metrics before sending them.
+// When enabled, counters are summed, only the last value of gauges is kept and
+// the values of sets are de-duplicated per bucket and tags. The aggregated
+// metrics are sent when the Client is flushed, so FlushPeriod should not be 0.
+//
+// By default, metrics are not aggregated. This option is ignored in
+// Client.Clone().
+func Aggregate(b bool) Option {
+	return Option(func(c *config) {
+		c.Conn.Aggregate = b
+	})
+}
+
+// ExtendedAggregate sets whether the Client buffers the values of timings,
+// histograms and distributions per bucket and tags and sends them packed in a
+// single line when flushed (e.g. bucket:1:2:3|ms). Lines are split so that
+// packets do not exceed the maximum packet size.
+//
+// Multi-value lines are only supported by DogStatsD >= 1.1. By default, values
+// are not buffered. This option is ignored in Client.Clone().
+func ExtendedAggregate(b bool) Option {
+	return Option(func(c *config) {
+		c.Conn.ExtendedAggregate = b
+	})
+}
+
+// Sanitize sets how the Client handles the characters that would corrupt the
+// StatsD line protocol (e.g. ':', '|' or a newline) in prefixes, buckets,
+// service check names, tag keys and values and set values. The reserved
+// characters depend on the tag format: for example ',' and '=' are also
+// reserved with InfluxDB while ':' is allowed in Datadog tag values. Custom tag
+// formats only get the common rules.
+//
+// By default, values are sent unchanged. This option is ignored in
+// Client.Clone().
+func Sanitize(mode SanitizeMode) Option {
+	return Option(func(c *config) {
+		c.Conn.SanitizeMode = mode
+	})
+}
+
+// ResolvePeriod sets how often the host of the address is resolved again. When
+// its IP addresses change, for example after a DNS update, the Client connects
+// to the new address. If p is 0, the address is only resolved when dialing.
+//
+// By default, the address is not resolved again. This option is ignored with
+// Unix sockets and in Client.Clone().
+func ResolvePeriod(p time.Duration) Option {
+	return Option(func(c *config) {
+		c.Conn.ResolvePeriod = p
+	})
+}
+
+// Telemetry sets how often the Client sends its own statistics (see
+// Client.Stats) as statsd.client.* counters. These counters are not counted in
+// MetricsQueued but, since they share the packets of the other metrics, they
+// are included in PacketsSent and BytesSent. If p is 0, the statistics are not
+// sent.
+//
+// By default, the statistics are not sent. This option is ignored in
+// Client.Clone().
+func Telemetry(p time.Duration) Option {
+	return Option(func(c *config) {
+		c.Conn.Telemetry = p
+	})
+}
+
+// QueuePolicy represents what a Client does when its send queue is full.
+type QueuePolicy uint8
+
+const (
+	// DropWhenFull drops the packet that cannot be queued.
+	DropWhenFull QueuePolicy = iota
+	// BlockWhenFull waits until the packet can be queued.
+	BlockWhenFull
+)
+
+// AsyncQueue sets whether the packets are sent by a background goroutine. When
+// size is greater than 0, full buffers are handed to the goroutine through a
+// queue of size packets so that a slow StatsD daemon does not block the
+// Client. When the queue is full, the policy decides whether the packet is
+// dropped or the Client waits.
+//
+// By default, packets are sent synchronously. This option is ignored in
+// Client.Clone().
+func AsyncQueue(size int, policy QueuePolicy) Option {
+	return Option(func(c *config) {
+		c.Conn.QueueSize = size
+		c.Conn.QueuePolicy = policy
+	})
+}
+
+// BufferShards sets the number of buffers the metrics are spread across. Each
+// buffer has its own lock and is flushed on its own, which reduces the
+// contention when many goroutines send metrics concurrently. Each metric is
+// assigned to a buffer by a hash of its bucket and tags so its values are
+// always sent in order, but different metrics may be sent in a different
+// order than they were recorded. Packets still respect MaxPacketSize.
+//
+// By default, a single buffer is used. This option is ignored in
+// Client.Clone().
+func BufferShards(n int) Option {
+	return Option(func(c *config) {
+		c.Conn.Shards = n
+	})
+}
+
 // Mute sets whether the Client is muted. All methods of a muted Client do
 // nothing and return immedialtly.
 //
@@ -95,6 +279,29 @@ func Mute(b bool) Option {
 	})
 }
 
+// CloseConnection sets whether closing the Client closes the connection it
+// shares with its parent and its clones.
+//
+// By default, only closing the Client returned by New closes the connection,
+// closing a clone just flushes the buffer. This option is not inherited by
+// the clones.
+func CloseConnection(b bool) Option {
+	return Option(func(c *config) {
+		c.Client.CloseConn = b
+	})
+}
+
+// HashTags sets whether the tags of a metric are used along with its bucket to
+// choose the node of a ClusterClient that receives it.
+//
+// By default, only the bucket is used. This option is only used by
+// NewClusterClient.
+func HashTags(b bool) Option {
+	return Option(func(c *config) {
+		c.Client.HashTags = b
+	})
+}
+
 // SampleRate sets the sample rate of the Client. It allows sending the metrics
 // less often which can be useful for performance intensive code paths.
 func SampleRate(rate float32) Option {
@@ -116,8 +323,58 @@ func Prefix(p string) Option {
 // TagFormat represents the format of tags sent by a Client.
 type TagFormat uint8
 
-// TagsFormat sets the format of tags.
-func TagsFormat(tf TagFormat) Option {
+// Join implements the TagFormatter interface.
+func (tf TagFormat) Join(tags []string) string {
+	return joinTags(tf, pairsToTags(tags))
+}
+
+// Split implements the TagFormatter interface.
+func (tf TagFormat) Split(s string) []string {
+	return tagsToPairs(splitTags(tf, s))
+}
+
+// Placement implements the TagFormatter interface.
+func (tf TagFormat) Placement() TagPlacement {
+	switch tf {
+	case Datadog:
+		return TagsAfterType
+	case SignalFx:
+		return TagsBeforeBucket
+	}
+	return TagsAfterBucket
+}
+
+// A TagFormatter formats the tags sent by a Client. TagFormat implements
+// TagFormatter for the formats supported by this package, other formats can be
+// supported by passing a custom TagFormatter to the TagsFormat option.
+type TagFormatter interface {
+	// Join returns the formatted tags. The tags are given as key-value pairs.
+	Join(tags []string) string
+	// Split parses the tags formatted by Join and returns them as key-value
+	// pairs.
+	Split(s string) []string
+	// Placement returns where the formatted tags are placed in a metric.
+	Placement() TagPlacement
+}
+
+// TagPlacement represents where the tags are placed in a metric.
+type TagPlacement uint8
+
+const (
+	// TagsAfterBucket places the tags right after the bucket name:
+	// bucket<tags>:1|c
+	TagsAfterBucket TagPlacement = iota
+	// TagsBeforeBucket places the tags before the prefix and the bucket name:
+	// <tags>bucket:1|c
+	TagsBeforeBucket
+	// TagsAfterType places the tags at the end of the metric:
+	// bucket:1|c<tags>
+	TagsAfterType
+)
+
+// TagsFormat sets the format of tags. Either one of the TagFormat constants or
+// a custom TagFormatter can be used.
+func TagsFormat(tf TagFormatter) Option {
 	return Option(func(c *config) {
 		c.Conn.TagFormat = tf
 	})
@@ -137,51 +394,92 @@ func Tags(tags ...string) Option {
 	}
 
 	return Option(func(c *config) {
-		if len(tags) == 0 {
-			return
-		}
-
-		newTags := make([]tag, len(tags)/2)
-		for i := 0; i < len(tags)/2; i++ {
-			newTags[i] = tag{K: tags[2*i], V: tags[2*i+1]}
-		}
+		c.Client.Tags = mergeTags(c.Client.Tags, tags)
+	})
+}
 
-		for _, newTag := range newTags {
-			exists := false
-			for _, oldTag := range c.Client.Tags {
-				if newTag.K == oldTag.K {
-					exists = true
-					oldTag.V = newTag.V
-				}
-			}
-			if !exists {
-				c.Client.Tags = append(c.Client.Tags, tag{
-					K: newTag.K,
-					V: newTag.V,
-				})
+// RemoveTags removes the tags with the given keys from the tags sent with every
+// metrics. It is mostly useful in Client.Clone() to drop a tag inherited from
+// the parent Client.
+func RemoveTags(keys ...string) Option {
+	return Option(func(c *config) {
+		tags := c.Client.Tags[:0]
+		for _, t := range c.Client.Tags {
+			if !contains(keys, t.K) {
+				tags = append(tags, t)
 			}
 		}
+		c.Client.Tags = tags
 	})
 }
 
+func contains(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 type tag struct {
 	K, V string
 }
 
-func joinTags(tf TagFormat, tags []tag) string {
-	if len(tags) == 0 || tf == 0 {
+// mergeTags adds the given key-value pairs to tags. If a tag already exists,
+// its value is replaced.
+func mergeTags(tags []tag, kv []string) []tag {
+	for i := 0; i+1 < len(kv); i += 2 {
+		exists := false
+		for j := range tags {
+			if tags[j].K == kv[i] {
+				exists = true
+				tags[j].V = kv[i+1]
+			}
+		}
+		if !exists {
+			tags = append(tags, tag{K: kv[i], V: kv[i+1]})
+		}
+	}
+	return tags
+}
+
+func joinTags(f TagFormatter, tags []tag) string {
+	if len(tags) == 0 || f == nil || f == TagFormat(0) {
 		return ""
 	}
-	join := joinFuncs[tf]
-	return join(tags)
+	if tf, ok := f.(TagFormat); ok {
+		join := joinFuncs[tf]
+		return join(tags)
+	}
+	return f.Join(tagsToPairs(tags))
 }
 
-func splitTags(tf TagFormat, tags string) []tag {
-	if len(tags) == 0 || tf == 0 {
+func splitTags(f TagFormatter, tags string) []tag {
+	if len(tags) == 0 || f == nil || f == TagFormat(0) {
 		return nil
 	}
-	split := splitFuncs[tf]
-	return split(tags)
+	if tf, ok := f.(TagFormat); ok {
+		split := splitFuncs[tf]
+		return split(tags)
+	}
+	return pairsToTags(f.Split(tags))
+}
+
+func pairsToTags(kv []string) []tag {
+	tags := make([]tag, len(kv)/2)
+	for i := range tags {
+		tags[i] = tag{K: kv[2*i], V: kv[2*i+1]}
+	}
+	return tags
+}
+
+func tagsToPairs(tags []tag) []string {
+	kv := make([]string, 0, 2*len(tags))
+	for _, t := range tags {
+		kv = append(kv, t.K, t.V)
+	}
+	return kv
 }
 
 const (
@@ -191,6 +489,15 @@ const (
 	// Datadog tag format.
 	// See http://docs.datadoghq.com/guides/metrics/#tags
 	Datadog
+	// Graphite tag format, supported by Graphite >= 1.1 and carbon-c-relay.
+	// See http://graphite.readthedocs.io/en/latest/tags.html
+	Graphite
+	// SignalFx tag format.
+	// See https://github.com/signalfx/signalfx-agent/blob/master/docs/monitors/statsd.md
+	SignalFx
+	// Librato tag format.
+	// See https://github.com/librato/statsd-librato-backend#tags
+	Librato
 )
 
 var (
@@ -224,6 +531,47 @@ var (
 			}
 			return buf.String()
 		},
+		// Graphite tag format: ;tag1=value1;tag2=value2
+		// http://graphite.readthedocs.io/en/latest/tags.html
+		Graphite: func(tags []tag) string {
+			var buf bytes.Buffer
+			for _, tag := range tags {
+				_ = buf.WriteByte(';')
+				_, _ = buf.WriteString(tag.K)
+				_ = buf.WriteByte('=')
+				_, _ = buf.WriteString(tag.V)
+			}
+			return buf.String()
+		},
+		// SignalFx tag format: [tag1=value1,tag2=value2]
+		// https://github.com/signalfx/signalfx-agent/blob/master/docs/monitors/statsd.md
+		SignalFx: func(tags []tag) string {
+			buf := bytes.NewBufferString("[")
+			for i, tag := range tags {
+				if i > 0 {
+					_ = buf.WriteByte(',')
+				}
+				_, _ = buf.WriteString(tag.K)
+				_ = buf.WriteByte('=')
+				_, _ = buf.WriteString(tag.V)
+			}
+			_ = buf.WriteByte(']')
+			return buf.String()
+		},
+		// Librato tag format: #tag1=value1,tag2=value2
+		// https://github.com/librato/statsd-librato-backend#tags
+		Librato: func(tags []tag) string {
+			buf := bytes.NewBufferString("#")
+			for i, tag := range tags {
+				if i > 0 {
+					_ = buf.WriteByte(',')
+				}
+				_, _ = buf.WriteString(tag.K)
+				_ = buf.WriteByte('=')
+				_, _ = buf.WriteString(tag.V)
+			}
+			return buf.String()
+		},
 	}
 	splitFuncs = map[TagFormat]func(string) []tag{
 		InfluxDB: func(s string) []tag {
@@ -241,7 +589,38 @@ var (
 			pairs := strings.Split(s, ",")
 			tags := make([]tag, len(pairs))
 			for i, pair := range pairs {
-				kv := strings.Split(pair, ":")
+				// Tag values may contain colons.
+				kv := strings.SplitN(pair, ":", 2)
+				tags[i] = tag{K: kv[0], V: kv[1]}
+			}
+			return tags
+		},
+		Graphite: func(s string) []tag {
+			s = s[1:]
+			pairs := strings.Split(s, ";")
+			tags := make([]tag, len(pairs))
+			for i, pair := range pairs {
+				kv := strings.Split(pair, "=")
+				tags[i] = tag{K: kv[0], V: kv[1]}
+			}
+			return tags
+		},
+		SignalFx: func(s string) []tag {
+			s = s[1 : len(s)-1]
+			pairs := strings.Split(s, ",")
+			tags := make([]tag, len(pairs))
+			for i, pair := range pairs {
+				kv := strings.Split(pair, "=")
+				tags[i] = tag{K: kv[0], V: kv[1]}
+			}
+			return tags
+		},
+		Librato: func(s string) []tag {
+			s = s[1:]
+			pairs := strings.Split(s, ",")
+			tags := make([]tag, len(pairs))
+			for i, pair := range pairs {
+				kv := strings.Split(pair, "=")
 				tags[i] = tag{K: kv[0], V: kv[1]}
 			}
 			return tags
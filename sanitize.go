@@ -0,0 +1,128 @@
+package statsd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SanitizeMode represents how a Client handles the reserved characters found
+// in prefixes, buckets, tags and set values.
+type SanitizeMode uint8
+
+const (
+	// SanitizeReplace replaces the reserved characters with underscores.
+	SanitizeReplace SanitizeMode = iota + 1
+	// SanitizeRemove removes the reserved characters.
+	SanitizeRemove
+	// SanitizeReport sends the values unchanged but reports the values
+	// containing reserved characters to the ErrorHandler.
+	SanitizeReport
+)
+
+// The characters that corrupt the StatsD line protocol whatever the tag
+// format.
+const (
+	bucketReserved = ":|\n"
+	tagReserved    = ":|\n,"
+	valueReserved  = ":|\n"
+)
+
+// datadogValueReserved holds the reserved characters in Datadog tag values.
+// Unlike the other formats, DogStatsD accepts colons in tag values (e.g.
+// url:http://example.com) since the tags follow the metric value.
+const datadogValueReserved = "|\n,"
+
+// reservedByFormat holds the additional reserved characters of each tag
+// format: first in buckets, then in tag keys and values.
+var reservedByFormat = map[TagFormat][2]string{
+	InfluxDB: {",", "="},
+	Graphite: {";", ";="},
+	SignalFx: {"[]", "=[]"},
+	Librato:  {"#", "=#"},
+}
+
+// reservedChars returns the reserved characters in buckets, in tag keys and in
+// tag values for the given tag format. Custom tag formats only get the common
+// rules.
+func reservedChars(f TagFormatter) (bucket, tagKey, tagValue string) {
+	bucket, tagKey, tagValue = bucketReserved, tagReserved, tagReserved
+	if tf, ok := f.(TagFormat); ok {
+		r := reservedByFormat[tf]
+		bucket += r[0]
+		tagKey += r[1]
+		tagValue += r[1]
+		if tf == Datadog {
+			tagValue = datadogValueReserved
+		}
+	}
+	return bucket, tagKey, tagValue
+}
+
+// sanitize handles the reserved characters of s according to the sanitize
+// mode of c.
+func (c *conn) sanitize(s, reserved string) string {
+	if c.sanitizeMode == 0 || !strings.ContainsAny(s, reserved) {
+		return s
+	}
+	switch c.sanitizeMode {
+	case SanitizeReport:
+		c.handleError(fmt.Errorf("statsd: %q contains reserved characters", s))
+		return s
+	case SanitizeRemove:
+		return strings.Map(func(r rune) rune {
+			if strings.ContainsRune(reserved, r) {
+				return -1
+			}
+			return r
+		}, s)
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(reserved, r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// sanitizeConfig sanitizes the prefix and the tags of a Client.
+func (c *conn) sanitizeConfig(conf *clientConfig) {
+	if c.sanitizeMode == 0 {
+		return
+	}
+	conf.Prefix = c.sanitize(conf.Prefix, c.bucketReserved)
+	c.sanitizeTags(conf.Tags)
+}
+
+// sanitizeTags sanitizes the keys and the values of tags in place.
+func (c *conn) sanitizeTags(tags []tag) {
+	if c.sanitizeMode == 0 {
+		return
+	}
+	for i := range tags {
+		tags[i].K = c.sanitize(tags[i].K, c.tagKeyReserved)
+		tags[i].V = c.sanitize(tags[i].V, c.tagValueReserved)
+	}
+}
+
+// sanitizePairs sanitizes the given key-value pairs. The slice is copied only
+// if a value is modified.
+func (c *conn) sanitizePairs(kv []string) []string {
+	if c.sanitizeMode == 0 {
+		return kv
+	}
+	copied := false
+	for i, s := range kv {
+		reserved := c.tagKeyReserved
+		if i%2 == 1 {
+			reserved = c.tagValueReserved
+		}
+		if clean := c.sanitize(s, reserved); clean != s {
+			if !copied {
+				kv = append([]string(nil), kv...)
+				copied = true
+			}
+			kv[i] = clean
+		}
+	}
+	return kv
+}
@@ -0,0 +1,127 @@
+package statsd
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ServiceCheck sends the status of a service. Service checks are only supported
+// by DogStatsD.
+//
+// The Client's tags are sent with the service check if the Client uses the
+// Datadog tag format. Service checks are not sampled.
+func (c *Client) ServiceCheck(name string, status ServiceCheckStatus, opts ...ServiceCheckOption) {
+	if c.muted || c.isClosed() {
+		return
+	}
+	sc := &serviceCheck{
+		name:   c.conn.sanitize(name, c.conn.bucketReserved),
+		status: status,
+	}
+	for _, o := range opts {
+		o(sc)
+	}
+	c.conn.sanitizeTags(sc.tags)
+	c.conn.serviceCheck(sc, c.tags)
+}
+
+// A ServiceCheckOption represents an option for a service check. It must be
+// used as an argument to Client.ServiceCheck().
+type ServiceCheckOption func(*serviceCheck)
+
+type serviceCheck struct {
+	name      string
+	status    ServiceCheckStatus
+	timestamp time.Time
+	hostname  string
+	message   string
+	tags      []tag
+}
+
+// ServiceCheckStatus represents the status of a service.
+type ServiceCheckStatus uint8
+
+const (
+	// StatusOK means that the service is healthy.
+	StatusOK ServiceCheckStatus = iota
+	// StatusWarning means that the service is degraded.
+	StatusWarning
+	// StatusCritical means that the service is down.
+	StatusCritical
+	// StatusUnknown means that the status of the service is unknown.
+	StatusUnknown
+)
+
+// ServiceCheckTimestamp sets the date of the service check.
+//
+// By default, DogStatsD uses the date at which it received the service check.
+func ServiceCheckTimestamp(t time.Time) ServiceCheckOption {
+	return ServiceCheckOption(func(sc *serviceCheck) {
+		sc.timestamp = t
+	})
+}
+
+// ServiceCheckHostname sets the name of the host associated with the service
+// check.
+func ServiceCheckHostname(h string) ServiceCheckOption {
+	return ServiceCheckOption(func(sc *serviceCheck) {
+		sc.hostname = h
+	})
+}
+
+// ServiceCheckMessage sets a message describing the status of the service.
+func ServiceCheckMessage(m string) ServiceCheckOption {
+	return ServiceCheckOption(func(sc *serviceCheck) {
+		sc.message = m
+	})
+}
+
+// ServiceCheckTags appends the given tags to the tags sent with the service
+// check.
+//
+// The tags must be set as key-value pairs. If the number of tags is not even,
+// ServiceCheckTags panics.
+func ServiceCheckTags(tags ...string) ServiceCheckOption {
+	if len(tags)%2 != 0 {
+		panic("statsd: ServiceCheckTags only accepts an even number of arguments")
+	}
+
+	return ServiceCheckOption(func(sc *serviceCheck) {
+		for i := 0; i < len(tags); i += 2 {
+			sc.tags = append(sc.tags, tag{K: tags[i], V: tags[i+1]})
+		}
+	})
+}
+
+// messageReplacer escapes the characters that cannot appear in the message of
+// a service check.
+var messageReplacer = strings.NewReplacer("\n", "\\n", "m:", "m\\:")
+
+// serviceCheck appends a service check using the DogStatsD datagram format:
+// _sc|name|status|d:timestamp|h:hostname|#tag1:value1,tag2:value2|m:message
+func (c *conn) serviceCheck(sc *serviceCheck, tags string) {
+	s := c.shard("", sc.name, tags)
+	atomic.AddUint64(&s.stats.metricsQueued, 1)
+	s.mu.Lock()
+	s.appendServiceCheck(sc, tags)
+	s.mu.Unlock()
+}
+
+func (c *conn) appendServiceCheck(sc *serviceCheck, tags string) {
+	l := len(c.buf)
+	c.appendString("_sc|")
+	c.appendString(sc.name)
+	c.appendByte('|')
+	c.buf = strconv.AppendUint(c.buf, uint64(sc.status), 10)
+	if !sc.timestamp.IsZero() {
+		c.appendString("|d:")
+		c.buf = strconv.AppendInt(c.buf, sc.timestamp.Unix(), 10)
+	}
+	c.appendField("h", sc.hostname)
+	c.appendDatadogTags(tags, sc.tags)
+	c.appendField("m", messageReplacer.Replace(sc.message))
+	c.appendByte('\n')
+	c.flushIfBufferFull(l)
+}
@@ -0,0 +1,104 @@
+package statsd
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds statistics about a Client. All the values are counted since
+// the creation of the Client and are shared with its clones.
+type Stats struct {
+	// MetricsQueued is the number of metrics, events and service checks
+	// accepted by the Client.
+	MetricsQueued uint64
+	// MetricsSampled is the number of metrics not sent because of the sample
+	// rate.
+	MetricsSampled uint64
+	// PacketsSent is the number of packets written to the connection.
+	PacketsSent uint64
+	// BytesSent is the number of bytes written to the connection.
+	BytesSent uint64
+	// WriteErrors is the number of failed writes.
+	WriteErrors uint64
+	// PacketsDropped is the number of packets lost because the socket buffer
+	// or the send queue was full or because too many packets were pending
+	// while the connection was down.
+	PacketsDropped uint64
+	// SizeFlushes is the number of flushes triggered because the buffer was
+	// full.
+	SizeFlushes uint64
+	// PeriodicFlushes is the number of flushes triggered by the FlushPeriod.
+	PeriodicFlushes uint64
+}
+
+// connStats holds the counters of a conn. They must be accessed atomically.
+type connStats struct {
+	metricsQueued   uint64
+	metricsSampled  uint64
+	packetsSent     uint64
+	bytesSent       uint64
+	writeErrors     uint64
+	packetsDropped  uint64
+	sizeFlushes     uint64
+	periodicFlushes uint64
+}
+
+// Stats returns a snapshot of the statistics of the Client.
+func (c *Client) Stats() Stats {
+	return c.conn.snapshot()
+}
+
+// snapshot returns the sum of the statistics of all the shards.
+func (c *conn) snapshot() Stats {
+	var s Stats
+	for _, sh := range c.shards {
+		s.MetricsQueued += atomic.LoadUint64(&sh.stats.metricsQueued)
+		s.MetricsSampled += atomic.LoadUint64(&sh.stats.metricsSampled)
+		s.PacketsSent += atomic.LoadUint64(&sh.stats.packetsSent)
+		s.BytesSent += atomic.LoadUint64(&sh.stats.bytesSent)
+		s.WriteErrors += atomic.LoadUint64(&sh.stats.writeErrors)
+		s.PacketsDropped += atomic.LoadUint64(&sh.stats.packetsDropped)
+		s.SizeFlushes += atomic.LoadUint64(&sh.stats.sizeFlushes)
+		s.PeriodicFlushes += atomic.LoadUint64(&sh.stats.periodicFlushes)
+	}
+	return s
+}
+
+// telemetryPrefix is the prefix of the metrics sent by sendTelemetry.
+const telemetryPrefix = "statsd.client."
+
+// sendTelemetry periodically sends the statistics of c as counters until c is
+// closed.
+func (c *conn) sendTelemetry() {
+	ticker := time.NewTicker(c.telemetry)
+	defer ticker.Stop()
+	var last Stats
+	for range ticker.C {
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+
+		s := c.snapshot()
+		c.telemetryCount("metrics_queued", s.MetricsQueued-last.MetricsQueued)
+		c.telemetryCount("metrics_sampled", s.MetricsSampled-last.MetricsSampled)
+		c.telemetryCount("packets_sent", s.PacketsSent-last.PacketsSent)
+		c.telemetryCount("bytes_sent", s.BytesSent-last.BytesSent)
+		c.telemetryCount("write_errors", s.WriteErrors-last.WriteErrors)
+		c.telemetryCount("packets_dropped", s.PacketsDropped-last.PacketsDropped)
+		c.telemetryCount("flushes.size", s.SizeFlushes-last.SizeFlushes)
+		c.telemetryCount("flushes.period", s.PeriodicFlushes-last.PeriodicFlushes)
+		last = s
+	}
+}
+
+// telemetryCount appends a telemetry counter. It bypasses the aggregator and
+// is not counted in MetricsQueued so that the telemetry does not count itself.
+func (c *conn) telemetryCount(name string, n uint64) {
+	s := c.shard(telemetryPrefix, name, "")
+	s.mu.Lock()
+	s.appendMetric(telemetryPrefix, name, uintValue(n), "c", 1, "")
+	s.mu.Unlock()
+}
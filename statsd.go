@@ -1,6 +1,12 @@
 package statsd
 
-import "time"
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+)
 
 // A Client represents a StatsD client.
 type Client struct {
@@ -9,6 +15,12 @@ type Client struct {
 	rate   float32
 	prefix string
 	tags   string
+	// tagList holds the tags of the Client before they are joined.
+	tagList []tag
+	// closeConn is true if closing the Client closes the connection.
+	closeConn bool
+	// closed is accessed atomically.
+	closed uint32
 }
 
 // New returns a new Client.
@@ -16,36 +28,57 @@ func New(opts ...Option) (*Client, error) {
 	// The default configuration.
 	conf := &config{
 		Client: clientConfig{
-			Rate: 1,
+			Rate:      1,
+			CloseConn: true,
 		},
 		Conn: connConfig{
-			Addr:        ":8125",
-			FlushPeriod: 100 * time.Millisecond,
-			// Worst-case scenario:
-			// Ethernet MTU - IPv6 Header - TCP Header = 1500 - 40 - 20 = 1440
-			MaxPacketSize: 1440,
+			Addr:          ":8125",
+			FlushPeriod:   100 * time.Millisecond,
+			MaxPacketSize: -1,
 			Network:       "udp",
+			DialTimeout:   5 * time.Second,
+			ReconnectMin:  100 * time.Millisecond,
+			ReconnectMax:  10 * time.Second,
+			MaxPending:    64,
+			WriteTimeout:  100 * time.Millisecond,
 		},
 	}
 	for _, o := range opts {
 		o(conf)
 	}
+	if conf.Conn.MaxPacketSize < 0 {
+		conf.Conn.MaxPacketSize = defaultMaxPacketSize(conf.Conn.Network)
+	}
 
 	conn, err := newConn(conf.Conn, conf.Client.Muted)
 	c := &Client{
-		conn:  conn,
-		muted: conf.Client.Muted,
+		conn:      conn,
+		muted:     conf.Client.Muted,
+		closeConn: conf.Client.CloseConn,
 	}
 	if err != nil {
 		c.muted = true
 		return c, err
 	}
+	conn.sanitizeConfig(&conf.Client)
 	c.rate = conf.Client.Rate
 	c.prefix = conf.Client.Prefix
 	c.tags = joinTags(conf.Conn.TagFormat, conf.Client.Tags)
+	c.tagList = conf.Client.Tags
 	return c, nil
 }
 
+func defaultMaxPacketSize(network string) int {
+	if strings.HasPrefix(network, "unix") {
+		// Unix sockets are not subject to the MTU, 8 KB datagrams are
+		// accepted by the DogStatsD agent.
+		return 8192
+	}
+	// Worst-case scenario:
+	// Ethernet MTU - IPv6 Header - TCP Header = 1500 - 40 - 20 = 1440
+	return 1440
+}
+
 // Clone returns a clone of the Client. The cloned Client inherits its
 // configuration from its parent.
 //
@@ -57,63 +90,116 @@ func (c *Client) Clone(opts ...Option) *Client {
 		Client: clientConfig{
 			Rate:   c.rate,
 			Prefix: c.prefix,
-			Tags:   splitTags(tf, c.tags),
+			Tags:   append([]tag(nil), c.tagList...),
 		},
 	}
 	for _, o := range opts {
 		o(conf)
 	}
+	c.conn.sanitizeConfig(&conf.Client)
 
 	clone := &Client{
-		conn:   c.conn,
-		muted:  c.muted || conf.Client.Muted,
-		rate:   conf.Client.Rate,
-		prefix: conf.Client.Prefix,
-		tags:   joinTags(tf, conf.Client.Tags),
+		conn:      c.conn,
+		muted:     c.muted || conf.Client.Muted,
+		rate:      conf.Client.Rate,
+		prefix:    conf.Client.Prefix,
+		tags:      joinTags(tf, conf.Client.Tags),
+		tagList:   conf.Client.Tags,
+		closeConn: conf.Client.CloseConn,
 	}
 	clone.conn = c.conn
 	return clone
 }
 
 // Count adds n to bucket.
-func (c *Client) Count(bucket string, n interface{}) {
+//
+// The optional tags are sent along with the Client's tags for this metric only.
+// They must be set as key-value pairs, a tag with the same key as one of the
+// Client's tags replaces it. If the number of tags is not even, an error is
+// reported to the ErrorHandler and the last key is ignored. The same applies to
+// all the methods sending metrics.
+//
+// n must be an integer or a float, otherwise an error is reported to the
+// ErrorHandler and nothing is sent. Use CountInt64 or CountFloat64 to avoid
+// allocating.
+func (c *Client) Count(bucket string, n interface{}, tags ...string) {
 	if c.skip() {
 		return
 	}
-	c.conn.metric(c.prefix, bucket, n, "c", c.rate, c.tags)
+	if v, ok := c.conn.number(n); ok {
+		c.conn.metric(c.prefix, bucket, v, "c", c.rate, c.withTags(tags))
+	}
 }
 
 func (c *Client) skip() bool {
-	return c.muted || (c.rate != 1 && randFloat() > c.rate)
+	if c.muted || c.isClosed() {
+		return true
+	}
+	if c.rate != 1 && randFloat() > c.rate {
+		atomic.AddUint64(&c.conn.stats.metricsSampled, 1)
+		return true
+	}
+	return false
+}
+
+// withTags returns the Client's tags merged with the given key-value pairs.
+func (c *Client) withTags(tags []string) string {
+	if len(tags)%2 != 0 {
+		c.conn.handleError(errors.New("statsd: tags must be key-value pairs"))
+		tags = tags[:len(tags)-1]
+	}
+	if len(tags) == 0 {
+		return c.tags
+	}
+	merged := make([]tag, len(c.tagList), len(c.tagList)+len(tags)/2)
+	copy(merged, c.tagList)
+	return joinTags(c.conn.tagFormat, mergeTags(merged, c.conn.sanitizePairs(tags)))
 }
 
 // Increment increment the given bucket. It is equivalent to Count(bucket, 1).
-func (c *Client) Increment(bucket string) {
-	c.Count(bucket, 1)
+func (c *Client) Increment(bucket string, tags ...string) {
+	c.CountInt64(bucket, 1, tags...)
 }
 
 // Gauge records an absolute value for the given bucket.
-func (c *Client) Gauge(bucket string, value interface{}) {
+func (c *Client) Gauge(bucket string, value interface{}, tags ...string) {
 	if c.skip() {
 		return
 	}
-	c.conn.gauge(c.prefix, bucket, value, c.tags)
+	if v, ok := c.conn.number(value); ok {
+		c.conn.gauge(c.prefix, bucket, v, c.withTags(tags))
+	}
 }
 
 // Timing sends a timing value to a bucket.
-func (c *Client) Timing(bucket string, value interface{}) {
+func (c *Client) Timing(bucket string, value interface{}, tags ...string) {
 	if c.skip() {
 		return
 	}
-	c.conn.metric(c.prefix, bucket, value, "ms", c.rate, c.tags)
+	if v, ok := c.conn.number(value); ok {
+		c.conn.metric(c.prefix, bucket, v, "ms", c.rate, c.withTags(tags))
+	}
 }
 
 // Histogram sends an histogram value to a bucket.
-func (c *Client) Histogram(bucket string, value interface{}) {
+func (c *Client) Histogram(bucket string, value interface{}, tags ...string) {
 	if c.skip() {
 		return
 	}
-	c.conn.metric(c.prefix, bucket, value, "h", c.rate, c.tags)
+	if v, ok := c.conn.number(value); ok {
+		c.conn.metric(c.prefix, bucket, v, "h", c.rate, c.withTags(tags))
+	}
+}
+
+// Distribution sends a distribution value to a bucket. Distributions are
+// aggregated globally by Datadog, they are only supported by DogStatsD.
+func (c *Client) Distribution(bucket string, value interface{}, tags ...string) {
+	if c.skip() {
+		return
+	}
+	if v, ok := c.conn.number(value); ok {
+		c.conn.metric(c.prefix, bucket, v, "d", c.rate, c.withTags(tags))
+	}
 }
 
 // A Timing is an helper object that eases sending timing values.
@@ -128,8 +214,8 @@ func (c *Client) NewTiming() Timing {
 }
 
 // Send sends the time elapsed since the creation of the Timing.
-func (t Timing) Send(bucket string) {
-	t.c.Timing(bucket, int(t.Duration()/time.Millisecond))
+func (t Timing) Send(bucket string, tags ...string) {
+	t.c.TimingInt64(bucket, int64(t.Duration()/time.Millisecond), tags...)
 }
 
 // Duration returns the time elapsed since the creation of the Timing.
@@ -138,32 +224,73 @@ func (t Timing) Duration() time.Duration {
 }
 
 // Unique sends the given value to a set bucket.
-func (c *Client) Unique(bucket string, value string) {
+func (c *Client) Unique(bucket string, value string, tags ...string) {
 	if c.skip() {
 		return
 	}
-	c.conn.unique(c.prefix, bucket, value, c.tags)
+	c.conn.unique(c.prefix, bucket, value, c.withTags(tags))
 }
 
 // Flush flushes the Client's buffer.
 func (c *Client) Flush() {
-	if c.muted {
+	if c.muted || c.isClosed() {
 		return
 	}
-	c.conn.mu.Lock()
-	c.conn.flush(0)
-	c.conn.mu.Unlock()
+	c.conn.flushShards()
+}
+
+// FlushContext flushes the Client's buffer and waits until the packets are
+// written, including the ones queued for the background sender. The writes
+// fail if they cannot complete before the deadline of ctx.
+//
+// It returns the errors of the writes made meanwhile or ctx.Err() if ctx is
+// done first. The errors are also reported to the ErrorHandler.
+func (c *Client) FlushContext(ctx context.Context) error {
+	if c.muted || c.isClosed() {
+		return nil
+	}
+	return c.conn.flushContext(ctx)
+}
+
+// CloseContext is like Close but it honours the deadline of ctx and returns the
+// errors that prevented the last packets from being sent, like FlushContext.
+func (c *Client) CloseContext(ctx context.Context) error {
+	if !c.markClosed() {
+		return nil
+	}
+	if !c.closeConn {
+		return c.conn.flushContext(ctx)
+	}
+	return c.conn.wait(ctx, func() {
+		c.conn.close(ctx)
+	})
 }
 
-// Close flushes the Client's buffer and releases the associated ressources. The
-// Client and all the cloned Clients must not be used afterward.
+// Close flushes the Client's buffer and releases the associated ressources.
+// Afterward, all the methods of the Client do nothing and calling Close again
+// has no effect.
+//
+// Closing a clone only flushes the buffer unless the CloseConnection option
+// was used. Closing the Client created by New closes the connection shared
+// with its clones so they do nothing afterward.
 func (c *Client) Close() {
-	if c.muted {
+	if !c.markClosed() {
+		return
+	}
+	if !c.closeConn {
+		c.conn.flushShards()
 		return
 	}
-	c.conn.mu.Lock()
-	c.conn.flush(0)
-	c.conn.handleError(c.conn.w.Close())
-	c.conn.closed = true
-	c.conn.mu.Unlock()
+	c.conn.close(context.Background())
+}
+
+// markClosed marks c as closed. It returns false if c is muted or has already
+// been closed.
+func (c *Client) markClosed() bool {
+	return !c.muted && atomic.CompareAndSwapUint32(&c.closed, 0, 1)
+}
+
+// isClosed reports whether c or its connection has been closed.
+func (c *Client) isClosed() bool {
+	return atomic.LoadUint32(&c.closed) == 1 || atomic.LoadUint32(&c.conn.closing) == 1
 }
@@ -2,10 +2,15 @@ package statsd
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -49,6 +54,87 @@ func TestHistogram(t *testing.T) {
 	})
 }
 
+func TestDistribution(t *testing.T) {
+	testOutput(t, "test_key:17.5|d", func(c *Client) {
+		c.Distribution(testKey, 17.5)
+	})
+}
+
+func TestDistributionSampledWithTags(t *testing.T) {
+	testOutput(t, "test_key:3|d|@0.6|#tag1:value1", func(c *Client) {
+		randFloat = func() float32 { return 0.5 }
+		c.Distribution(testKey, 3)
+	}, SampleRate(0.6), TagsFormat(Datadog), Tags("tag1", "value1"))
+}
+
+func TestEvent(t *testing.T) {
+	testOutput(t, "_e{5,11}:title|first\\nline", func(c *Client) {
+		c.Event("title", "first\nline")
+	})
+}
+
+func TestEventTitleNewline(t *testing.T) {
+	testOutput(t, "_e{7,4}:ti\\ntle|text", func(c *Client) {
+		c.Event("ti\ntle", "text")
+	})
+}
+
+func TestEventOptions(t *testing.T) {
+	testOutput(t,
+		"_e{5,4}:title|text|d:1445532780|h:host|k:key|p:low|s:nagios|t:error"+
+			"|#tag1:value1,tag2:value2",
+		func(c *Client) {
+			c.Event("title", "text",
+				EventTimestamp(testDate),
+				EventHostname("host"),
+				EventAggregationKey("key"),
+				EventPriorityLevel(PriorityLow),
+				EventSourceType("nagios"),
+				EventAlert(AlertError),
+				EventTags("tag2", "value2"),
+			)
+		}, TagsFormat(Datadog), Tags("tag1", "value1"))
+}
+
+func TestEventTagsWithoutDatadog(t *testing.T) {
+	testOutput(t, "_e{5,4}:title|text|#tag2:value2", func(c *Client) {
+		c.Event("title", "text", EventTags("tag2", "value2"))
+	}, TagsFormat(InfluxDB), Tags("tag1", "value1"))
+}
+
+func TestEventMaxPacketSize(t *testing.T) {
+	testClient(t, func(c *Client) {
+		c.Increment(testKey)
+		c.Event("title", "text")
+		got := getOutput(c)
+		want := "test_key:1|c"
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+		c.Close()
+	}, MaxPacketSize(20))
+}
+
+func TestServiceCheck(t *testing.T) {
+	testOutput(t, "_sc|my_service|0", func(c *Client) {
+		c.ServiceCheck("my_service", StatusOK)
+	})
+}
+
+func TestServiceCheckOptions(t *testing.T) {
+	testOutput(t,
+		"_sc|my_service|2|d:1445532780|h:host|#tag1:value1,tag2:value2"+
+			"|m:disk full\\nm\\: 100%",
+		func(c *Client) {
+			c.ServiceCheck("my_service", StatusCritical,
+				ServiceCheckTimestamp(testDate),
+				ServiceCheckHostname("host"),
+				ServiceCheckTags("tag2", "value2"),
+				ServiceCheckMessage("disk full\nm: 100%"),
+			)
+		}, TagsFormat(Datadog), Tags("tag1", "value1"))
+}
+
 func TestNumbers(t *testing.T) {
 	testOutput(t,
 		"test_key:1|g\n"+
@@ -63,8 +149,7 @@ func TestNumbers(t *testing.T) {
 			"test_key:1|g\n"+
 			"test_key:17.6|g\n"+
 			"test_key:0|g\n"+
-			"test_key:-42.5|g\n"+
-			"test_key:|g",
+			"test_key:-42.5|g",
 		func(c *Client) {
 			c.Gauge(testKey, 1)
 			c.Gauge(testKey, uint(1))
@@ -78,10 +163,133 @@ func TestNumbers(t *testing.T) {
 			c.Gauge(testKey, uint8(1))
 			c.Gauge(testKey, float64(17.6))
 			c.Gauge(testKey, float32(-42.5))
-			c.Gauge(testKey, "invalid")
 		})
 }
 
+func TestUnsupportedType(t *testing.T) {
+	errorCount := 0
+	testOutput(t, "", func(c *Client) {
+		c.Count(testKey, "1")
+		c.Gauge(testKey, "invalid")
+		c.Timing(testKey, time.Second)
+		c.Histogram(testKey, nil)
+		c.Distribution(testKey, true)
+		if errorCount != 5 {
+			t.Errorf("Wrong error count, got %d, want 5", errorCount)
+		}
+	}, ErrorHandler(func(error) {
+		errorCount++
+	}))
+}
+
+func TestTypedMethods(t *testing.T) {
+	testOutput(t,
+		"test_key:-3|c\n"+
+			"test_key:0.5|c\n"+
+			"test_key:0|g\n"+
+			"test_key:-7|g\n"+
+			"test_key:2.5|g\n"+
+			"test_key:12|ms\n"+
+			"test_key:1.5|ms\n"+
+			"test_key:1500.5|ms|#tag1:value1\n"+
+			"test_key:4|h\n"+
+			"test_key:4.5|h\n"+
+			"test_key:9|d\n"+
+			"test_key:9.5|d",
+		func(c *Client) {
+			c.CountInt64(testKey, -3)
+			c.CountFloat64(testKey, 0.5)
+			c.GaugeInt64(testKey, -7)
+			c.GaugeFloat64(testKey, 2.5)
+			c.TimingInt64(testKey, 12)
+			c.TimingFloat64(testKey, 1.5)
+			c.TimingDuration(testKey, 1500500*time.Microsecond, "tag1", "value1")
+			c.HistogramInt64(testKey, 4)
+			c.HistogramFloat64(testKey, 4.5)
+			c.DistributionInt64(testKey, 9)
+			c.DistributionFloat64(testKey, 9.5)
+		}, TagsFormat(Datadog))
+}
+
+func TestTypedMethodsDoNotAllocate(t *testing.T) {
+	testClient(t, func(c *Client) {
+		conn := getBuffer(c)
+		conn.buf.Grow(1 << 20)
+		i := int64(0)
+		allocs := testing.AllocsPerRun(100, func() {
+			i++
+			c.CountInt64(testKey, i)
+			c.GaugeFloat64(testKey, float64(i))
+			c.TimingDuration(testKey, time.Duration(i))
+			c.Increment(testKey)
+		})
+		if allocs != 0 {
+			t.Errorf("Typed methods allocated %v times per run", allocs)
+		}
+		c.Close()
+	})
+}
+
+func TestHandles(t *testing.T) {
+	testOutput(t,
+		"app.test_key:1|c|#tag1:value1"+
+			"app.test_key:5|c|#tag1:value1\n"+
+			"app.test_key:0|g|#tag1:value1,tag2:value2\n"+
+			"app.test_key:-3|g|#tag1:value1,tag2:value2\n"+
+			"app.test_key:2.5|g|#tag1:value1,tag2:value2\n"+
+			"app.test_key:1.5|ms|#tag1:value1\n"+
+			"app.test_key:17|h|#tag1:value1\n"+
+			"app.test_key:foo|s|#tag1:value1",
+		func(c *Client) {
+			counter := c.NewCounter(testKey)
+			counter.Increment()
+			c.Flush()
+			counter.Add(5)
+			gauge := c.NewGauge(testKey, "tag2", "value2")
+			gauge.Set(-3)
+			gauge.SetFloat64(2.5)
+			c.NewTimer(testKey).Record(1500 * time.Microsecond)
+			c.NewHistogram(testKey).Record(17)
+			c.NewSet(testKey).Add("foo")
+		}, TagsFormat(Datadog), Prefix("app"), Tags("tag1", "value1"))
+}
+
+func TestHandlesInfluxDB(t *testing.T) {
+	testOutput(t, "test_key,tag1=value1:1|c", func(c *Client) {
+		c.NewCounter(testKey).Increment()
+	}, TagsFormat(InfluxDB), Tags("tag1", "value1"))
+}
+
+func TestHandlesSampling(t *testing.T) {
+	testOutput(t, "test_key:3|c|@0.6", func(c *Client) {
+		counter := c.NewCounter(testKey)
+		randFloat = func() float32 { return 0.5 }
+		counter.Add(3)
+		randFloat = func() float32 { return 0.8 }
+		counter.Add(4)
+	}, SampleRate(0.6))
+}
+
+func TestHandlesMuted(t *testing.T) {
+	testOutput(t, "", func(c *Client) {
+		clone := c.Clone(Mute(true))
+		clone.NewCounter(testKey).Increment()
+		clone.NewGauge(testKey).Set(1)
+		clone.NewSet(testKey).Add("foo")
+	})
+}
+
+func TestHandlesAggregate(t *testing.T) {
+	testOutput(t, "test_key:2|c\ntest_key:bar|s", func(c *Client) {
+		counter := c.NewCounter(testKey)
+		counter.Increment()
+		counter.Increment()
+		set := c.NewSet(testKey)
+		set.Add("bar")
+		set.Add("bar")
+	}, Aggregate(true))
+}
+
 func TestNewTiming(t *testing.T) {
 	i := 0
 	now = func() time.Time {
@@ -134,7 +342,10 @@ func TestMute(t *testing.T) {
 	c.Gauge(testKey, 1)
 	c.Timing(testKey, 1)
 	c.Histogram(testKey, 1)
+	c.Distribution(testKey, 1)
 	c.Unique(testKey, "1")
+	c.Event("title", "text")
+	c.ServiceCheck("my_service", StatusOK)
 	c.Flush()
 	c.Close()
 }
@@ -179,95 +390,1000 @@ func TestDatadogTags(t *testing.T) {
 	}, TagsFormat(Datadog), Tags("tag1", "value1", "tag2", "value2"))
 }
 
-func TestNoTagFormat(t *testing.T) {
-	testOutput(t, "test_key:1|c", func(c *Client) {
-		c.Increment(testKey)
-	}, Tags("tag1", "value1", "tag2", "value2"))
+func TestCallTags(t *testing.T) {
+	testOutput(t,
+		"test_key:1|c|#tag1:value1,tag2:value2\n"+
+			"test_key:5|g|#tag1:value3\n"+
+			"test_key:1|c|#tag1:value1",
+		func(c *Client) {
+			c.Increment(testKey, "tag2", "value2")
+			c.Gauge(testKey, 5, "tag1", "value3")
+			c.Increment(testKey)
+		}, TagsFormat(Datadog), Tags("tag1", "value1"))
+}
+
+func TestCallTagsInfluxDB(t *testing.T) {
+	testOutput(t,
+		"test_key,tag1=value1,tag2=value2:6|ms\n"+
+			"test_key,tag1=value3:foo|s",
+		func(c *Client) {
+			c.Timing(testKey, 6, "tag2", "value2")
+			c.Unique(testKey, "foo", "tag1", "value3")
+		}, TagsFormat(InfluxDB), Tags("tag1", "value1"))
+}
+
+func TestCallTagsWithoutClientTags(t *testing.T) {
+	testOutput(t, "test_key:17|h|#tag1:value1", func(c *Client) {
+		c.Histogram(testKey, 17, "tag1", "value1")
+	}, TagsFormat(Datadog))
+}
+
+func TestOddCallTags(t *testing.T) {
+	errorCount := 0
+	testOutput(t, "test_key:1|c|#tag1:value1", func(c *Client) {
+		c.Count(testKey, 1, "tag1", "value1", "tag2")
+		if errorCount != 1 {
+			t.Errorf("Wrong error count, got %d, want 1", errorCount)
+		}
+	}, TagsFormat(Datadog), ErrorHandler(func(err error) {
+		if err.Error() != "statsd: tags must be key-value pairs" {
+			t.Errorf("Invalid error: %v", err)
+		}
+		errorCount++
+	}))
+}
+
+func TestGraphiteTags(t *testing.T) {
+	testOutput(t, "test_key;tag1=value1;tag2=value2:1|c", func(c *Client) {
+		c.Increment(testKey)
+	}, TagsFormat(Graphite), Tags("tag1", "value1", "tag2", "value2"))
+}
+
+func TestSignalFxTags(t *testing.T) {
+	testOutput(t, "[tag1=value1,tag2=value2]app.test_key:1|c", func(c *Client) {
+		c.Increment(testKey)
+	}, TagsFormat(SignalFx), Prefix("app"), Tags("tag1", "value1", "tag2", "value2"))
+}
+
+func TestLibratoTags(t *testing.T) {
+	testOutput(t, "test_key#tag1=value1,tag2=value2:1|c", func(c *Client) {
+		c.Increment(testKey)
+	}, TagsFormat(Librato), Tags("tag1", "value1", "tag2", "value2"))
+}
+
+// openTSDBFormat formats tags like OpenTSDB: {tag1=value1,tag2=value2}
+type openTSDBFormat struct{}
+
+func (openTSDBFormat) Join(tags []string) string {
+	s := "{"
+	for i := 0; i < len(tags); i += 2 {
+		if i > 0 {
+			s += ","
+		}
+		s += tags[i] + "=" + tags[i+1]
+	}
+	return s + "}"
+}
+
+func (openTSDBFormat) Split(s string) []string {
+	var tags []string
+	for _, pair := range strings.Split(s[1:len(s)-1], ",") {
+		tags = append(tags, strings.Split(pair, "=")...)
+	}
+	return tags
+}
+
+func (openTSDBFormat) Placement() TagPlacement {
+	return TagsAfterBucket
+}
+
+func TestCustomTags(t *testing.T) {
+	testOutput(t,
+		"test_key{tag1=value1,tag2=value2}:1|c\n"+
+			"test_key{tag1=value3,tag2=value2}:5|c",
+		func(c *Client) {
+			c.Increment(testKey)
+			c.Clone(Tags("tag1", "value3")).Count(testKey, 5)
+		}, TagsFormat(openTSDBFormat{}), Tags("tag1", "value1", "tag2", "value2"))
+}
+
+func TestTagFormatSplit(t *testing.T) {
+	got := Datadog.Split(Datadog.Join([]string{"tag1", "value1", "tag2", "value2"}))
+	want := []string{"tag1", "value1", "tag2", "value2"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Invalid tags, got %q, want %q", got, want)
+	}
+}
+
+func TestSanitizeReplace(t *testing.T) {
+	testOutput(t,
+		"a_b.test_key,tag_1=val_ue1,tag2=value_2:1|c\n"+
+			"a_b.test_key_,tag_1=val_ue1:foo_bar|s",
+		func(c *Client) {
+			c.Increment(testKey, "tag2", "value,2")
+			c.Unique("test_key|", "foo:bar")
+		}, TagsFormat(InfluxDB), Prefix("a:b"), Tags("tag=1", "val\nue1"), Sanitize(SanitizeReplace))
+}
+
+func TestSanitizeRemove(t *testing.T) {
+	testOutput(t, "test_key:1|c|#tag1:value1", func(c *Client) {
+		c.Increment("test:_key", "tag1", "value,1")
+	}, TagsFormat(Datadog), Sanitize(SanitizeRemove))
+}
+
+func TestSanitizeEventTags(t *testing.T) {
+	testOutput(t, "_e{5,4}:title|text|#tag_1:value_1", func(c *Client) {
+		c.Event("title", "text", EventTags("tag|1", "value,1"))
+	}, TagsFormat(Datadog), Sanitize(SanitizeReplace))
+}
+
+func TestSanitizeServiceCheck(t *testing.T) {
+	testOutput(t, "_sc|my_service|0|#tag_1:value_1", func(c *Client) {
+		c.ServiceCheck("my|service", StatusOK, ServiceCheckTags("tag:1", "value,1"))
+	}, TagsFormat(Datadog), Sanitize(SanitizeReplace))
+}
+
+func TestSanitizeDatadogValueColon(t *testing.T) {
+	testOutput(t, "test_key:1|c|#url:http://a,image:redis:6,tag_1:value1", func(c *Client) {
+		c.Increment(testKey, "image", "redis:6", "tag:1", "value1")
+	}, TagsFormat(Datadog), Tags("url", "http://a"), Sanitize(SanitizeReplace))
+}
+
+func TestSanitizeReport(t *testing.T) {
+	errorCount := 0
+	testOutput(t, "test;key;tag1=value1:1|c", func(c *Client) {
+		c.Increment("test;key", "tag1", "value1")
+		if errorCount != 1 {
+			t.Errorf("Wrong error count, got %d, want 1", errorCount)
+		}
+	}, TagsFormat(Graphite), Sanitize(SanitizeReport), ErrorHandler(func(error) {
+		errorCount++
+	}))
+}
+
+func TestNoTagFormat(t *testing.T) {
+	testOutput(t, "test_key:1|c", func(c *Client) {
+		c.Increment(testKey)
+	}, Tags("tag1", "value1", "tag2", "value2"))
+}
+
+func TestOddTagsArgs(t *testing.T) {
+	dialTimeout = mockDial
+	defer func() { dialTimeout = net.DialTimeout }()
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("Tags should panic when only one argument is provided")
+		}
+	}()
+
+	_, _ = New(TagsFormat(InfluxDB), Tags("tag1"))
+	t.Fatal("A panic should occur")
+}
+
+func TestErrorHandler(t *testing.T) {
+	errorCount := 0
+	testClient(t, func(c *Client) {
+		getBuffer(c).err = errors.New("test error")
+		c.Increment(testKey)
+		c.Close()
+		if errorCount != 2 {
+			t.Errorf("Wrong error count, got %d, want 2", errorCount)
+		}
+	}, ErrorHandler(func(err error) {
+		if err == nil {
+			t.Error("Error should not be nil")
+		}
+		errorCount++
+	}))
+}
+
+func TestReconnect(t *testing.T) {
+	errorCount := 0
+	testClient(t, func(c *Client) {
+		getBuffer(c).err = errors.New("test error")
+		dialed := make(chan *testBuffer, 1)
+		dialTimeout = func(string, string, time.Duration) (net.Conn, error) {
+			conn := &testBuffer{}
+			dialed <- conn
+			return conn, nil
+		}
+
+		c.Increment(testKey)
+		c.Flush()
+		conn := <-dialed
+		waitConnected(c)
+		if c.conn.w != conn {
+			t.Fatal("The Client did not reconnect")
+		}
+		c.Close()
+
+		got := conn.buf.String()
+		want := "test_key:1|c\n"
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+		// The failed write and the Close of the broken connection.
+		if errorCount != 2 {
+			t.Errorf("Wrong error count, got %d, want 2", errorCount)
+		}
+	}, Network("tcp"), ErrorHandler(func(error) {
+		errorCount++
+	}))
+}
+
+func TestStreamWriteTimeout(t *testing.T) {
+	testClient(t, func(c *Client) {
+		getBuffer(c).err = &net.OpError{Op: "write", Net: "tcp", Err: os.ErrDeadlineExceeded}
+		dialed := make(chan *testBuffer, 1)
+		dialTimeout = func(string, string, time.Duration) (net.Conn, error) {
+			conn := &testBuffer{}
+			dialed <- conn
+			return conn, nil
+		}
+
+		c.Increment(testKey)
+		c.Flush()
+		conn := <-dialed
+		waitConnected(c)
+		c.Close()
+
+		got := conn.buf.String()
+		want := "test_key:1|c\n"
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+		if got := c.Stats().PacketsDropped; got != 0 {
+			t.Errorf("Wrong dropped packets count, got %d, want 0", got)
+		}
+	}, Network("tcp"), ErrorHandler(func(error) {}))
+}
+
+func TestReconnectWithoutMax(t *testing.T) {
+	testClient(t, func(c *Client) {
+		getBuffer(c).err = errors.New("test error")
+		var mu sync.Mutex
+		dials := 0
+		conn := &testBuffer{}
+		dialTimeout = func(string, string, time.Duration) (net.Conn, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if dials++; dials < 4 {
+				return nil, errors.New("test error")
+			}
+			return conn, nil
+		}
+
+		c.Increment(testKey)
+		c.Flush()
+		waitConnected(c)
+		c.Close()
+
+		if got := conn.buf.String(); got != "test_key:1|c\n" {
+			t.Errorf("Invalid output, got %q, want %q", got, "test_key:1|c\n")
+		}
+	}, Network("tcp"), ReconnectBackoff(time.Millisecond, 0), ErrorHandler(func(error) {}))
+}
+
+func TestReconnectDisabled(t *testing.T) {
+	testClient(t, func(c *Client) {
+		conn := getBuffer(c)
+		conn.err = &net.OpError{Op: "write", Net: "tcp", Err: os.ErrDeadlineExceeded}
+		dialTimeout = func(string, string, time.Duration) (net.Conn, error) {
+			t.Error("The Client should not reconnect")
+			return nil, errors.New("test error")
+		}
+
+		c.Increment(testKey)
+		c.Flush()
+		conn.err = nil
+		c.Count(testKey, 2)
+		c.Close()
+
+		if c.conn.w != conn {
+			t.Error("The connection should be kept")
+		}
+		got := conn.buf.String()
+		want := "test_key:2|c\n"
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+	}, Network("tcp"), ReconnectBackoff(0, 0), ErrorHandler(func(error) {}))
+}
+
+func TestMaxPendingPackets(t *testing.T) {
+	testClient(t, func(c *Client) {
+		c.conn.wmu.Lock()
+		c.conn.w = nil
+		c.conn.wmu.Unlock()
+
+		c.Count(testKey, 1)
+		c.Flush()
+		c.Count(testKey, 2)
+		c.Flush()
+		c.Count(testKey, 3)
+		c.Flush()
+
+		conn := &testBuffer{}
+		c.conn.wmu.Lock()
+		c.conn.w = conn
+		c.conn.flushPending()
+		c.conn.wmu.Unlock()
+
+		got := conn.buf.String()
+		want := "test_key:2|ctest_key:3|c"
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+		c.Close()
+	}, MaxPendingPackets(2))
+}
+
+func waitConnected(c *Client) {
+	for {
+		c.conn.wmu.Lock()
+		connected := c.conn.w != nil
+		c.conn.wmu.Unlock()
+		if connected {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestResolvePeriod(t *testing.T) {
+	var mu sync.Mutex
+	ip := "10.0.0.1"
+	lookupHost = func(host string) ([]string, error) {
+		if host != "statsd.local" {
+			t.Errorf("Invalid host, got %q, want %q", host, "statsd.local")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return []string{ip}, nil
+	}
+	defer func() { lookupHost = net.LookupHost }()
+
+	testClient(t, func(c *Client) {
+		old := getBuffer(c)
+		conn := &testBuffer{}
+		dialTimeout = func(string, string, time.Duration) (net.Conn, error) {
+			return conn, nil
+		}
+		mu.Lock()
+		ip = "10.0.0.2"
+		mu.Unlock()
+		for {
+			c.conn.wmu.Lock()
+			swapped := c.conn.w == conn
+			c.conn.wmu.Unlock()
+			if swapped {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		c.Increment(testKey)
+		c.Close()
+		if got := conn.buf.String(); got != "test_key:1|c" {
+			t.Errorf("Invalid output, got %q, want %q", got, "test_key:1|c")
+		}
+		if old.closes != 1 {
+			t.Error("The previous connection was not closed")
+		}
+	}, Address("statsd.local:8125"), ResolvePeriod(time.Millisecond))
+}
+
+func TestResolvePeriodDisconnected(t *testing.T) {
+	var mu sync.Mutex
+	ip := "10.0.0.1"
+	lookupHost = func(string) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return []string{ip}, nil
+	}
+	defer func() { lookupHost = net.LookupHost }()
+
+	testClient(t, func(c *Client) {
+		c.conn.wmu.Lock()
+		c.conn.w = nil
+		c.conn.wmu.Unlock()
+		conn := &notifyingBuffer{closed: make(chan struct{})}
+		dialTimeout = func(string, string, time.Duration) (net.Conn, error) {
+			return conn, nil
+		}
+		mu.Lock()
+		ip = "10.0.0.2"
+		mu.Unlock()
+
+		// The new connection is discarded since the conn is reconnecting.
+		<-conn.closed
+		c.Close()
+		c.conn.wmu.Lock()
+		if c.conn.w != nil {
+			t.Error("The new connection should not be used")
+		}
+		c.conn.wmu.Unlock()
+	}, Address("statsd.local:8125"), ResolvePeriod(time.Millisecond))
+}
+
+func TestFlush(t *testing.T) {
+	testClient(t, func(c *Client) {
+		c.Increment(testKey)
+		c.Flush()
+		got := getOutput(c)
+		want := "test_key:1|c"
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+		c.Close()
+	})
+}
+
+func TestFlushPeriod(t *testing.T) {
+	testClient(t, func(c *Client) {
+		c.Increment(testKey)
+		time.Sleep(time.Millisecond)
+		c.conn.mu.Lock()
+		got := getOutput(c)
+		want := "test_key:1|c"
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+		c.conn.mu.Unlock()
+		c.Close()
+	}, FlushPeriod(time.Nanosecond))
+}
+
+func TestMaxPacketSize(t *testing.T) {
+	testClient(t, func(c *Client) {
+		c.Increment(testKey)
+		conn := getBuffer(c)
+		got := conn.buf.String()
+		if got != "" {
+			t.Errorf("Output should be empty, got %q", got)
+		}
+
+		c.Increment(testKey)
+		got = conn.buf.String()
+		want := "test_key:1|c"
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+		conn.buf.Reset()
+		c.Close()
+
+		got = conn.buf.String()
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+	}, MaxPacketSize(15))
+}
+
+func TestAggregate(t *testing.T) {
+	testOutput(t,
+		// Timings are not aggregated so they are sent first.
+		"test_key:6|ms\n"+
+			"test_key:6|c\n"+
+			"test_key:0|g\n"+
+			"test_key:-3|g\n"+
+			"test_key:foo|s\n"+
+			"test_key:bar|s\n"+
+			"test_key:1.5|c|#tag1:value1",
+		func(c *Client) {
+			c.Count(testKey, 1)
+			c.Gauge(testKey, 5)
+			c.Unique(testKey, "foo")
+			c.Count(testKey, 5)
+			c.Gauge(testKey, -3)
+			c.Unique(testKey, "bar")
+			c.Unique(testKey, "foo")
+			c.Count(testKey, 0.5, "tag1", "value1")
+			c.Count(testKey, float32(1), "tag1", "value1")
+			c.Timing(testKey, 6)
+		}, Aggregate(true), TagsFormat(Datadog))
+}
+
+func TestAggregateLargeCounters(t *testing.T) {
+	testOutput(t,
+		"test_key:1152921504606846977|c\n"+
+			"test_key:18446744073709551615|c|#tag1:value1",
+		func(c *Client) {
+			c.CountInt64(testKey, 1<<60)
+			c.CountInt64(testKey, 1)
+			c.Count(testKey, uint64(1<<64-2), "tag1", "value1")
+			c.Count(testKey, 1, "tag1", "value1")
+		}, Aggregate(true), TagsFormat(Datadog))
+}
+
+func TestAggregateFlush(t *testing.T) {
+	testClient(t, func(c *Client) {
+		c.Increment(testKey)
+		c.Increment(testKey)
+		if got := getOutput(c); got != "" {
+			t.Errorf("Output should be empty, got %q", got)
+		}
+		c.Flush()
+		c.Increment(testKey)
+		c.Close()
+
+		got := getOutput(c)
+		want := "test_key:2|ctest_key:1|c"
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+	}, Aggregate(true))
+}
+
+func TestAggregateFlushPeriod(t *testing.T) {
+	testClient(t, func(c *Client) {
+		c.Increment(testKey)
+		c.Increment(testKey)
+		time.Sleep(time.Millisecond)
+		c.conn.mu.Lock()
+		got := getOutput(c)
+		want := "test_key:2|c"
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+		c.conn.mu.Unlock()
+		c.Close()
+	}, Aggregate(true), FlushPeriod(time.Nanosecond))
+}
+
+func TestExtendedAggregate(t *testing.T) {
+	testOutput(t,
+		"test_key:1|c\n"+
+			"test_key:1:2.5:3|ms\n"+
+			"test_key:4:5|h|@0.6|#tag1:value1\n"+
+			"test_key:6|d",
+		func(c *Client) {
+			randFloat = func() float32 { return 0.5 }
+			sampled := c.Clone(SampleRate(0.6))
+			c.Timing(testKey, 1)
+			sampled.Histogram(testKey, 4, "tag1", "value1")
+			c.Timing(testKey, 2.5)
+			c.Distribution(testKey, 6)
+			c.Increment(testKey)
+			c.Timing(testKey, int64(3))
+			sampled.Histogram(testKey, uint8(5), "tag1", "value1")
+		}, ExtendedAggregate(true), TagsFormat(Datadog))
+}
+
+func TestExtendedAggregateMaxPacketSize(t *testing.T) {
+	testClient(t, func(c *Client) {
+		for i := 10; i < 20; i++ {
+			c.Timing(testKey, i)
+		}
+		c.Close()
+
+		got := getBuffer(c).buf.String()
+		want := "test_key:10:11:12|ms" +
+			"test_key:13:14:15|ms" +
+			"test_key:16:17:18|ms" +
+			"test_key:19|ms"
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+	}, ExtendedAggregate(true), MaxPacketSize(21))
+}
+
+func TestStats(t *testing.T) {
+	testClient(t, func(c *Client) {
+		randFloat = func() float32 { return 0.5 }
+		c.Increment(testKey)
+		c.Clone(SampleRate(0.3)).Increment(testKey)
+		c.Increment(testKey)
+		c.Event("title", "text")
+		c.Flush()
+		c.Flush()
+
+		got := c.Stats()
+		want := Stats{
+			MetricsQueued:  3,
+			MetricsSampled: 1,
+			PacketsSent:    3,
+			BytesSent:      uint64(len("test_key:1|ctest_key:1|c_e{5,4}:title|text")),
+			SizeFlushes:    2,
+		}
+		if got != want {
+			t.Errorf("Invalid stats, got %+v, want %+v", got, want)
+		}
+		c.Close()
+	}, MaxPacketSize(25))
+}
+
+func TestStatsWriteErrors(t *testing.T) {
+	testClient(t, func(c *Client) {
+		getBuffer(c).err = errors.New("test error")
+		c.Increment(testKey)
+		c.Flush()
+		if got := c.Stats().WriteErrors; got != 1 {
+			t.Errorf("Wrong write errors count, got %d, want 1", got)
+		}
+	}, ErrorHandler(func(error) {}))
+}
+
+func TestTelemetry(t *testing.T) {
+	testClient(t, func(c *Client) {
+		c.Increment(testKey)
+		c.Flush()
+		// Wait for the first batch of telemetry metrics to be fully appended.
+		for {
+			c.conn.mu.Lock()
+			sent := strings.Contains(getOutput(c)+string(c.conn.buf), "flushes.period")
+			c.conn.mu.Unlock()
+			if sent {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if got := c.Stats().MetricsQueued; got != 1 {
+			t.Errorf("Wrong queued metrics count, got %d, want 1", got)
+		}
+		c.Close()
+
+		got := getOutput(c)
+		want := "test_key:1|c" +
+			"statsd.client.metrics_queued:1|c\n" +
+			"statsd.client.metrics_sampled:0|c\n" +
+			"statsd.client.packets_sent:1|c\n" +
+			"statsd.client.bytes_sent:12|c\n" +
+			"statsd.client.write_errors:0|c\n" +
+			"statsd.client.packets_dropped:0|c\n" +
+			"statsd.client.flushes.size:0|c\n" +
+			"statsd.client.flushes.period:0|c"
+		if !strings.HasPrefix(got, want) {
+			t.Errorf("Invalid output, got %q, want prefix %q", got, want)
+		}
+	}, Telemetry(10*time.Millisecond))
+}
+
+func TestAsyncQueue(t *testing.T) {
+	testOutput(t, "test_key:1|ctest_key:2|ctest_key:3|c", func(c *Client) {
+		c.Count(testKey, 1)
+		c.Count(testKey, 2)
+		c.Count(testKey, 3)
+	}, MaxPacketSize(25), AsyncQueue(8, BlockWhenFull))
+}
+
+func TestAsyncQueueDropWhenFull(t *testing.T) {
+	testClient(t, func(c *Client) {
+		conn := &blockingBuffer{release: make(chan struct{})}
+		c.conn.wmu.Lock()
+		c.conn.w = conn
+		c.conn.wmu.Unlock()
+
+		// The sender is stuck writing the first packet, the second one fills
+		// the queue and the others are dropped.
+		for i := 0; i < 5; i++ {
+			c.Increment(testKey)
+			c.Flush()
+		}
+		if got := c.Stats().PacketsDropped; got < 3 {
+			t.Errorf("Wrong dropped packets count, got %d, want at least 3", got)
+		}
+		close(conn.release)
+		c.Close()
+	}, AsyncQueue(1, DropWhenFull))
+}
+
+func TestFlushContext(t *testing.T) {
+	testClient(t, func(c *Client) {
+		conn := &deadlineBuffer{}
+		c.conn.wmu.Lock()
+		c.conn.w = conn
+		c.conn.wmu.Unlock()
+
+		deadline := time.Now().Add(time.Hour)
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		c.Increment(testKey)
+		if err := c.FlushContext(ctx); err != nil {
+			t.Errorf("FlushContext: %v", err)
+		}
+		if got := conn.buf.String(); got != "test_key:1|c" {
+			t.Errorf("Invalid output, got %q, want %q", got, "test_key:1|c")
+		}
+		want := []time.Time{deadline, {}}
+		if len(conn.deadlines) != 2 || !conn.deadlines[0].Equal(want[0]) || !conn.deadlines[1].IsZero() {
+			t.Errorf("Invalid deadlines, got %v, want %v", conn.deadlines, want)
+		}
+		c.Close()
+	})
+}
+
+func TestFlushContextErrors(t *testing.T) {
+	testClient(t, func(c *Client) {
+		getBuffer(c).err = errors.New("test error")
+		c.Increment(testKey)
+		c.Flush()
+		c.Increment(testKey)
+		if err := c.FlushContext(context.Background()); err == nil || err.Error() != "test error" {
+			t.Errorf("FlushContext returned %v, want test error", err)
+		}
+		if err := c.CloseContext(context.Background()); err == nil || err.Error() != "test error" {
+			t.Errorf("CloseContext returned %v, want test error", err)
+		}
+	}, ErrorHandler(func(error) {}))
+}
+
+func TestFlushContextAsync(t *testing.T) {
+	testClient(t, func(c *Client) {
+		c.Increment(testKey)
+		c.Flush()
+		c.Increment(testKey)
+		if err := c.FlushContext(context.Background()); err != nil {
+			t.Errorf("FlushContext: %v", err)
+		}
+		got := getOutput(c)
+		want := "test_key:1|ctest_key:1|c"
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+		c.Close()
+	}, AsyncQueue(8, BlockWhenFull))
+}
+
+func TestCloseContextTimeout(t *testing.T) {
+	testClient(t, func(c *Client) {
+		conn := &blockingBuffer{release: make(chan struct{})}
+		c.conn.wmu.Lock()
+		c.conn.w = conn
+		c.conn.wmu.Unlock()
+
+		c.Increment(testKey)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := c.CloseContext(ctx); err != context.DeadlineExceeded {
+			t.Errorf("CloseContext returned %v, want %v", err, context.DeadlineExceeded)
+		}
+		close(conn.release)
+	}, AsyncQueue(8, BlockWhenFull))
+}
+
+func TestWaitQueueCancel(t *testing.T) {
+	testClient(t, func(c *Client) {
+		conn := &blockingBuffer{release: make(chan struct{})}
+		c.conn.wmu.Lock()
+		c.conn.w = conn
+		c.conn.wmu.Unlock()
+
+		// The first packet blocks the sender and the second one fills the
+		// queue.
+		c.Increment(testKey)
+		c.Flush()
+		for len(c.conn.queue) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		c.Increment(testKey)
+		c.Flush()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		waited := make(chan struct{})
+		go func() {
+			c.conn.waitQueue(ctx)
+			c.Flush()
+			close(waited)
+		}()
+		select {
+		case <-waited:
+		case <-time.After(time.Second):
+			t.Error("waitQueue did not return when ctx was done")
+		}
+		close(conn.release)
+		c.Close()
+	}, AsyncQueue(1, DropWhenFull))
+}
+
+func TestCloseTwice(t *testing.T) {
+	testClient(t, func(c *Client) {
+		conn := getBuffer(c)
+		c.Close()
+		c.Close()
+		if err := c.CloseContext(context.Background()); err != nil {
+			t.Errorf("CloseContext: %v", err)
+		}
+		if conn.closes != 1 {
+			t.Errorf("The connection was closed %d times, want 1", conn.closes)
+		}
+	}, AsyncQueue(8, BlockWhenFull))
+}
+
+func TestUseAfterClose(t *testing.T) {
+	testOutput(t, "test_key:1|c", func(c *Client) {
+		c.Increment(testKey)
+		counter := c.NewCounter(testKey)
+		c.Close()
+
+		c.Increment(testKey)
+		c.Gauge(testKey, 1)
+		c.Unique(testKey, "foo")
+		c.Event("title", "text")
+		c.ServiceCheck("my_service", StatusOK)
+		counter.Increment()
+		c.Clone().Increment(testKey)
+		c.Flush()
+		if err := c.FlushContext(context.Background()); err != nil {
+			t.Errorf("FlushContext: %v", err)
+		}
+		if got := c.Stats().MetricsQueued; got != 1 {
+			t.Errorf("Wrong queued metrics count, got %d, want 1", got)
+		}
+	})
+}
+
+func TestCloseClone(t *testing.T) {
+	testOutput(t, "test_key:1|ctest_key:3|c", func(c *Client) {
+		clone := c.Clone()
+		clone.Count(testKey, 1)
+		clone.Close()
+		clone.Count(testKey, 2)
+		c.Count(testKey, 3)
+		if conn := getBuffer(c); conn.closes != 0 {
+			t.Error("Closing a clone closed the connection")
+		}
+	})
+}
+
+func TestCloseConnection(t *testing.T) {
+	testOutput(t, "test_key:1|c", func(c *Client) {
+		clone := c.Clone(CloseConnection(true))
+		clone.Count(testKey, 1)
+		clone.Close()
+		c.Count(testKey, 2)
+		if conn := getBuffer(c); conn.closes != 1 {
+			t.Error("Closing the clone did not close the connection")
+		}
+	})
+}
+
+func TestMultiClient(t *testing.T) {
+	dialTimeout = mockDial
+	defer func() { dialTimeout = net.DialTimeout }()
+
+	graphite, err := New(FlushPeriod(0), TagsFormat(Graphite), Tags("tag1", "value1"),
+		ErrorHandler(expectNoError(t)))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	datadog, err := New(FlushPeriod(0), TagsFormat(Datadog), Tags("tag1", "value1"),
+		ErrorHandler(expectNoError(t)))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	m := NewMultiClient(graphite, datadog)
+	m.Increment(testKey, "tag2", "value2")
+	m.Clone(Prefix("app")).Gauge(testKey, 5)
+	m.Close()
+
+	got := getOutput(graphite)
+	want := "test_key;tag1=value1;tag2=value2:1|c\napp.test_key;tag1=value1:5|g"
+	if got != want {
+		t.Errorf("Invalid Graphite output, got %q, want %q", got, want)
+	}
+	got = getOutput(datadog)
+	want = "test_key:1|c|#tag1:value1,tag2:value2\napp.test_key:5|g|#tag1:value1"
+	if got != want {
+		t.Errorf("Invalid Datadog output, got %q, want %q", got, want)
+	}
+}
+
+func TestMultiClientErrors(t *testing.T) {
+	dialTimeout = mockDial
+	defer func() { dialTimeout = net.DialTimeout }()
+
+	ok, err := New(Address(":1"), FlushPeriod(0), ErrorHandler(expectNoError(t)))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ko, err := New(Address(":2"), FlushPeriod(0), ErrorHandler(func(error) {}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	getBuffer(ko).err = errors.New("test error")
+
+	m := NewMultiClient(ok, ko)
+	m.Increment(testKey)
+	err = m.FlushContext(context.Background())
+	derr, isDestErr := err.(*DestinationError)
+	if !isDestErr || derr.Addr != ":2" || derr.Err.Error() != "test error" {
+		t.Errorf("Invalid error, got %v", err)
+	}
+	getBuffer(ko).err = nil
+	m.Close()
 }
 
-func TestOddTagsArgs(t *testing.T) {
+func TestClusterClient(t *testing.T) {
 	dialTimeout = mockDial
 	defer func() { dialTimeout = net.DialTimeout }()
 
-	defer func() {
-		r := recover()
-		if r == nil {
-			t.Fatal("Tags should panic when only one argument is provided")
-		}
-	}()
-
-	_, _ = New(TagsFormat(InfluxDB), Tags("tag1"))
-	t.Fatal("A panic should occur")
-}
+	c, err := NewClusterClient([]string{"node1:8125", "node2:8125", "node3:8125"},
+		FlushPeriod(0), ErrorHandler(expectNoError(t)))
+	if err != nil {
+		t.Fatalf("NewClusterClient: %v", err)
+	}
+	for i := 0; i < 30; i++ {
+		bucket := "bucket" + strconv.Itoa(i)
+		c.Increment(bucket)
+		c.Clone(Tags("tag1", "value1")).Increment(bucket)
+	}
+	c.Close()
 
-func TestErrorHandler(t *testing.T) {
-	errorCount := 0
-	testClient(t, func(c *Client) {
-		getBuffer(c).err = errors.New("test error")
-		c.Increment(testKey)
-		c.Close()
-		if errorCount != 2 {
-			t.Errorf("Wrong error count, got %d, want 2", errorCount)
+	for i := 0; i < 30; i++ {
+		line := "bucket" + strconv.Itoa(i) + ":1|c"
+		nodes := 0
+		for _, node := range c.Clients() {
+			if n := strings.Count(getOutput(node), line); n == 2 {
+				nodes++
+			} else if n != 0 {
+				t.Errorf("%q sent %d times to %s, want 0 or 2", line, n, node.conn.addr)
+			}
 		}
-	}, ErrorHandler(func(err error) {
-		if err == nil {
-			t.Error("Error should not be nil")
+		if nodes != 1 {
+			t.Errorf("%q sent to %d nodes, want 1", line, nodes)
 		}
-		errorCount++
-	}))
-}
-
-func TestFlush(t *testing.T) {
-	testClient(t, func(c *Client) {
-		c.Increment(testKey)
-		c.Flush()
-		got := getOutput(c)
-		want := "test_key:1|c"
-		if got != want {
-			t.Errorf("Invalid output, got %q, want %q", got, want)
+	}
+	for _, node := range c.Clients() {
+		if getOutput(node) == "" {
+			t.Errorf("No metric sent to %s", node.conn.addr)
 		}
-		c.Close()
-	})
+	}
 }
 
-func TestFlushPeriod(t *testing.T) {
-	testClient(t, func(c *Client) {
-		c.Increment(testKey)
-		time.Sleep(time.Millisecond)
-		c.conn.mu.Lock()
-		got := getOutput(c)
-		want := "test_key:1|c"
-		if got != want {
-			t.Errorf("Invalid output, got %q, want %q", got, want)
+func TestClusterClientNodeDown(t *testing.T) {
+	var mu sync.Mutex
+	down := true
+	dialTimeout = func(network, addr string, timeout time.Duration) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if addr == "node2:8125" && down {
+			down = false
+			return nil, errors.New("test error")
 		}
-		c.conn.mu.Unlock()
-		c.Close()
-	}, FlushPeriod(time.Nanosecond))
-}
+		return &testBuffer{}, nil
+	}
+	defer func() { dialTimeout = net.DialTimeout }()
 
-func TestMaxPacketSize(t *testing.T) {
-	testClient(t, func(c *Client) {
-		c.Increment(testKey)
-		conn := getBuffer(c)
-		got := conn.buf.String()
-		if got != "" {
-			t.Errorf("Output should be empty, got %q", got)
-		}
+	c, err := NewClusterClient([]string{"node1:8125", "node2:8125"},
+		FlushPeriod(0), ErrorHandler(func(error) {}))
+	derr, isDestErr := err.(*DestinationError)
+	if !isDestErr || derr.Addr != "node2:8125" {
+		t.Fatalf("Invalid error, got %v", err)
+	}
+	node := c.Clients()[1]
+	if node.muted {
+		t.Fatal("The Client of the unreachable node should not be muted")
+	}
+	for i := 0; i < 30; i++ {
+		c.Increment("bucket" + strconv.Itoa(i))
+	}
+	c.Flush()
+	waitConnected(node)
+	c.Close()
 
-		c.Increment(testKey)
-		got = conn.buf.String()
-		want := "test_key:1|c"
-		if got != want {
-			t.Errorf("Invalid output, got %q, want %q", got, want)
-		}
-		conn.buf.Reset()
-		c.Close()
+	if getOutput(node) == "" {
+		t.Error("The metrics of the unreachable node were lost")
+	}
+}
 
-		got = conn.buf.String()
-		if got != want {
-			t.Errorf("Invalid output, got %q, want %q", got, want)
+func TestRingConsistency(t *testing.T) {
+	all := newRing([]string{"node1:8125", "node2:8125", "node3:8125"})
+	// node3 removed, the indexes of node1 and node2 are the same.
+	less := newRing([]string{"node1:8125", "node2:8125"})
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		h := hashString(fnvOffset, "bucket"+strconv.Itoa(i))
+		if n := all.get(h); n != 2 && n != less.get(h) {
+			moved++
 		}
-	}, MaxPacketSize(15))
+	}
+	if moved != 0 {
+		t.Errorf("%d keys moved between the remaining nodes", moved)
+	}
 }
 
 func TestClone(t *testing.T) {
@@ -317,19 +1433,67 @@ func TestCloneRate(t *testing.T) {
 }
 
 func TestCloneInfluxDBTags(t *testing.T) {
-	testOutput(t, "test_key,tag1=value1,tag2=value2:5|c", func(c *Client) {
+	testOutput(t, "test_key,tag1=value3,tag2=value2:5|c", func(c *Client) {
 		clone := c.Clone(Tags("tag1", "value3", "tag2", "value2"))
 		clone.Count(testKey, 5)
 	}, TagsFormat(InfluxDB), Tags("tag1", "value1"))
 }
 
 func TestCloneDatadogTags(t *testing.T) {
-	testOutput(t, "test_key:5|c|#tag1:value1,tag2:value2", func(c *Client) {
+	testOutput(t, "test_key:5|c|#tag1:value3,tag2:value2", func(c *Client) {
 		clone := c.Clone(Tags("tag1", "value3", "tag2", "value2"))
 		clone.Count(testKey, 5)
 	}, TagsFormat(Datadog), Tags("tag1", "value1"))
 }
 
+func TestCloneDatadogTagsWithSeparators(t *testing.T) {
+	testOutput(t, "test_key:5|c|#url:http://a,list:a,b,tag2:value2", func(c *Client) {
+		clone := c.Clone(Tags("tag2", "value2"))
+		clone.Count(testKey, 5)
+	}, TagsFormat(Datadog), Tags("url", "http://a", "list", "a,b"))
+}
+
+func TestCloneKeepsParentTags(t *testing.T) {
+	testOutput(t, "test_key:5|c|#tag1:value3\ntest_key:5|c|#tag1:value1", func(c *Client) {
+		c.Clone(Tags("tag1", "value3")).Count(testKey, 5)
+		c.Count(testKey, 5)
+	}, TagsFormat(Datadog), Tags("tag1", "value1"))
+}
+
+func TestReplaceTags(t *testing.T) {
+	testOutput(t, "test_key:1|c|#tag1:value3,tag2:value2", func(c *Client) {
+		c.Increment(testKey)
+	}, TagsFormat(Datadog), Tags("tag1", "value1", "tag2", "value2"), Tags("tag1", "value3"))
+}
+
+func TestRemoveTags(t *testing.T) {
+	testOutput(t, "test_key:5|c|#tag2:value2\ntest_key:5|c", func(c *Client) {
+		c.Clone(RemoveTags("tag1", "tag3")).Count(testKey, 5)
+		c.Clone(RemoveTags("tag1", "tag2")).Count(testKey, 5)
+	}, TagsFormat(Datadog), Tags("tag1", "value1", "tag2", "value2"))
+}
+
+func TestCloneGraphiteTags(t *testing.T) {
+	testOutput(t, "test_key;tag1=value3;tag2=value2:5|c", func(c *Client) {
+		clone := c.Clone(Tags("tag1", "value3", "tag2", "value2"))
+		clone.Count(testKey, 5)
+	}, TagsFormat(Graphite), Tags("tag1", "value1"))
+}
+
+func TestCloneSignalFxTags(t *testing.T) {
+	testOutput(t, "[tag1=value3,tag2=value2]test_key:5|c", func(c *Client) {
+		clone := c.Clone(Tags("tag1", "value3", "tag2", "value2"))
+		clone.Count(testKey, 5)
+	}, TagsFormat(SignalFx), Tags("tag1", "value1"))
+}
+
+func TestCloneLibratoTags(t *testing.T) {
+	testOutput(t, "test_key#tag1=value3,tag2=value2:5|c", func(c *Client) {
+		clone := c.Clone(Tags("tag1", "value3", "tag2", "value2"))
+		clone.Count(testKey, 5)
+	}, TagsFormat(Librato), Tags("tag1", "value1"))
+}
+
 func TestDialError(t *testing.T) {
 	dialTimeout = func(string, string, time.Duration) (net.Conn, error) {
 		return nil, errors.New("")
@@ -345,6 +1509,66 @@ func TestDialError(t *testing.T) {
 	}
 }
 
+func TestBackgroundDial(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	conn := &testBuffer{}
+	dialTimeout = func(network, addr string, timeout time.Duration) (net.Conn, error) {
+		if timeout != time.Second {
+			t.Errorf("Invalid dial timeout, got %v, want %v", timeout, time.Second)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if attempts++; attempts < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return conn, nil
+	}
+	defer func() { dialTimeout = net.DialTimeout }()
+
+	c, err := New(
+		BackgroundDial(true),
+		DialTimeout(time.Second),
+		ReconnectBackoff(time.Millisecond, time.Millisecond),
+		FlushPeriod(0),
+		ErrorHandler(func(error) {}),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	c.Increment(testKey)
+	c.Flush()
+	waitConnected(c)
+	c.Close()
+
+	if got := conn.buf.String(); got != "test_key:1|c" {
+		t.Errorf("Invalid output, got %q, want %q", got, "test_key:1|c")
+	}
+}
+
+func TestBackgroundDialWithoutReconnect(t *testing.T) {
+	dialed := make(chan bool, 2)
+	dialTimeout = func(string, string, time.Duration) (net.Conn, error) {
+		dialed <- true
+		return nil, errors.New("connection refused")
+	}
+	defer func() { dialTimeout = net.DialTimeout }()
+
+	c, err := New(
+		BackgroundDial(true),
+		ReconnectBackoff(0, 0),
+		ErrorHandler(func(error) {}),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	<-dialed
+	c.Close()
+	if len(dialed) != 0 {
+		t.Error("The Client tried to dial again")
+	}
+}
+
 func TestConcurrency(t *testing.T) {
 	testOutput(t, "test_key:1|c\ntest_key:1|c\ntest_key:1|c", func(c *Client) {
 		var wg sync.WaitGroup
@@ -359,6 +1583,68 @@ func TestConcurrency(t *testing.T) {
 	})
 }
 
+func TestBufferShards(t *testing.T) {
+	testOutput(t,
+		"test_key:1|c\ntest_key:2|c\ntest_key:3|c\ntest_key:4|c\n"+
+			"test_key:5|c\ntest_key:6|c\ntest_key:7|c\ntest_key:8|c",
+		func(c *Client) {
+			for i := 1; i <= 8; i++ {
+				c.Count(testKey, i)
+			}
+			if got := c.Stats().MetricsQueued; got != 8 {
+				t.Errorf("Wrong queued metrics count, got %d, want 8", got)
+			}
+		}, BufferShards(4))
+}
+
+func TestBufferShardsGaugeOrder(t *testing.T) {
+	testClient(t, func(c *Client) {
+		buckets := []string{"a", "b", "c", "d", "e", "f"}
+		for i := 1; i <= 3; i++ {
+			for _, b := range buckets {
+				c.Gauge(b, i)
+			}
+		}
+		c.Close()
+
+		// The values of each gauge must be sent in order so that the last
+		// one wins.
+		got := getOutput(c)
+		for _, b := range buckets {
+			last := -1
+			for i := 1; i <= 3; i++ {
+				j := strings.Index(got, b+":"+strconv.Itoa(i)+"|g")
+				if j < last {
+					t.Errorf("Values of gauge %q are not in order: %q", b, got)
+				}
+				last = j
+			}
+		}
+	}, BufferShards(3))
+}
+
+func TestBufferShardsConcurrency(t *testing.T) {
+	testClient(t, func(c *Client) {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				for j := 0; j < 100; j++ {
+					c.Increment(testKey)
+				}
+				wg.Done()
+			}()
+		}
+		wg.Wait()
+		c.Close()
+
+		got := strings.Count(getOutput(c), testKey)
+		if got != 800 {
+			t.Errorf("Wrong number of metrics sent, got %d, want 800", got)
+		}
+	}, BufferShards(4), MaxPacketSize(100))
+}
+
 func TestUDPNotListening(t *testing.T) {
 	dialTimeout = mockUDPClosed
 	defer func() { dialTimeout = net.DialTimeout }()
@@ -428,8 +1714,9 @@ func expectNoError(t *testing.T) func(error) {
 }
 
 type testBuffer struct {
-	buf bytes.Buffer
-	err error
+	buf    bytes.Buffer
+	err    error
+	closes int
 	net.Conn
 }
 
@@ -441,9 +1728,47 @@ func (c *testBuffer) Write(p []byte) (int, error) {
 }
 
 func (c *testBuffer) Close() error {
+	c.closes++
 	return c.err
 }
 
+func (c *testBuffer) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// notifyingBuffer is a testBuffer that closes closed when it is closed.
+type notifyingBuffer struct {
+	testBuffer
+	closed chan struct{}
+}
+
+func (c *notifyingBuffer) Close() error {
+	close(c.closed)
+	return nil
+}
+
+// blockingBuffer is a testBuffer whose writes block until release is closed.
+type blockingBuffer struct {
+	testBuffer
+	release chan struct{}
+}
+
+func (c *blockingBuffer) Write(p []byte) (int, error) {
+	<-c.release
+	return c.testBuffer.Write(p)
+}
+
+// deadlineBuffer is a testBuffer that records the write deadlines.
+type deadlineBuffer struct {
+	testBuffer
+	deadlines []time.Time
+}
+
+func (c *deadlineBuffer) SetWriteDeadline(t time.Time) error {
+	c.deadlines = append(c.deadlines, t)
+	return nil
+}
+
 func getBuffer(c *Client) *testBuffer {
 	if mock, ok := c.conn.w.(*testBuffer); ok {
 		return mock
@@ -463,18 +1788,109 @@ func mockDial(string, string, time.Duration) (net.Conn, error) {
 }
 
 func TestUDP(t *testing.T) {
-	testNetwork(t, "udp")
+	testNetwork(t, "udp", testAddr)
 }
 
 func TestTCP(t *testing.T) {
-	testNetwork(t, "tcp")
+	testNetwork(t, "tcp", testAddr)
+}
+
+func TestUnixgram(t *testing.T) {
+	testNetwork(t, "unixgram", testSocket(t))
+}
+
+func TestUnix(t *testing.T) {
+	testNetwork(t, "unix", testSocket(t))
+}
+
+func TestTCPMultipleFlushes(t *testing.T) {
+	testStreamFlushes(t, "tcp", testAddr)
+}
+
+func TestUnixMultipleFlushes(t *testing.T) {
+	testStreamFlushes(t, "unix", testSocket(t))
+}
+
+// testStreamFlushes checks that the lines sent by several flushes over a
+// stream connection are not merged.
+func testStreamFlushes(t *testing.T, network, addr string) {
+	received := make(chan string)
+	server := newServer(t, network, addr, func(p []byte) {
+		received <- string(p)
+	})
+	defer server.Close()
+
+	c, err := New(
+		Address(server.addr),
+		Network(network),
+		MaxPacketSize(20),
+		FlushPeriod(0),
+		ErrorHandler(expectNoError(t)),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	c.Count(testKey, 1)
+	c.Flush()
+	c.Count(testKey, 2)
+	c.Count(testKey, 3) // Flushes test_key:2|c because of MaxPacketSize.
+	c.Flush()
+	c.Count(testKey, 4)
+	c.Close()
+
+	want := "test_key:1|c\ntest_key:2|c\ntest_key:3|c\ntest_key:4|c\n"
+	select {
+	case <-time.After(100 * time.Millisecond):
+		t.Error("server received nothing after 100ms")
+	case got := <-received:
+		if got != want {
+			t.Errorf("Invalid output, got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestUnixDefaultMaxPacketSize(t *testing.T) {
+	testClient(t, func(c *Client) {
+		if c.conn.maxPacketSize != 8192 {
+			t.Errorf("Invalid max packet size, got %d, want 8192", c.conn.maxPacketSize)
+		}
+		c.Close()
+	}, Network("unixgram"))
+}
+
+func TestUnixgramBufferFull(t *testing.T) {
+	errorCount := 0
+	testClient(t, func(c *Client) {
+		getBuffer(c).err = &net.OpError{Op: "write", Err: os.ErrDeadlineExceeded}
+		c.Increment(testKey)
+		c.Flush()
+		if got := c.Stats().PacketsDropped; got != 1 {
+			t.Errorf("Wrong dropped count, got %d, want 1", got)
+		}
+		if c.conn.w == nil {
+			t.Error("A full buffer should not close the connection")
+		}
+		if errorCount != 1 {
+			t.Errorf("Wrong error count, got %d, want 1", errorCount)
+		}
+	}, Network("unixgram"), ErrorHandler(func(error) {
+		errorCount++
+	}))
+}
+
+func testSocket(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "statsd.sock")
 }
 
-func testNetwork(t *testing.T, network string) {
+func testNetwork(t *testing.T, network, addr string) {
 	received := make(chan bool)
-	server := newServer(t, network, testAddr, func(p []byte) {
+	want := "test_key:1|c"
+	if isStream(network) {
+		want += "\n"
+	}
+	server := newServer(t, network, addr, func(p []byte) {
 		s := string(p)
-		if s != "test_key:1|c" {
+		if s != want {
 			t.Errorf("invalid output: %q", s)
 		}
 		received <- true
@@ -508,12 +1924,8 @@ type server struct {
 func newServer(t testing.TB, network, addr string, f func([]byte)) *server {
 	s := &server{t: t, closed: make(chan bool)}
 	switch network {
-	case "udp":
-		laddr, err := net.ResolveUDPAddr("udp", addr)
-		if err != nil {
-			t.Fatal(err)
-		}
-		conn, err := net.ListenUDP("udp", laddr)
+	case "udp", "unixgram":
+		conn, err := net.ListenPacket(network, addr)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -522,7 +1934,7 @@ func newServer(t testing.TB, network, addr string, f func([]byte)) *server {
 		go func() {
 			buf := make([]byte, 1024)
 			for {
-				n, err := conn.Read(buf)
+				n, _, err := conn.ReadFrom(buf)
 				if err != nil {
 					s.closed <- true
 					return
@@ -532,8 +1944,8 @@ func newServer(t testing.TB, network, addr string, f func([]byte)) *server {
 				}
 			}
 		}()
-	case "tcp":
-		ln, err := net.Listen("tcp", addr)
+	case "tcp", "unix":
+		ln, err := net.Listen(network, addr)
 		if err != nil {
 			t.Fatal(err)
 		}